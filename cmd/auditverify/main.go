@@ -0,0 +1,59 @@
+//Command auditverify checks the hash chain of audit log files.
+//
+//	auditverify -key audit.key -head audit.head audit.jsonl.<rotated>... audit.jsonl
+//
+//Files are given oldest first. The chain is verified with the
+//key it was written with and must end at the record in the
+//head file, which detects records cut from the end of the log
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/worlvlhole/clamav-plugin/internal/audit"
+)
+
+func main() {
+	keyPath := flag.String("key", "", "key file the chain was written with")
+	headPath := flag.String("head", "", "head file the chain must end at")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -key file -head file file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 || *keyPath == "" || *headPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	key, err := audit.ReadKey(*keyPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	head, err := audit.ReadHead(*headPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	start, last, n, err := audit.VerifyFiles(flag.Args(), key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if head.Seq != last.Seq || head.Hash != last.Hash {
+		fmt.Fprintf(os.Stderr, "%v: chain ends at seq %d, head is at seq %d\n", audit.ErrChain, last.Seq, head.Seq)
+		os.Exit(1)
+	}
+
+	if start.Seq > 0 {
+		fmt.Printf("chain starts after seq %d, older files were not given\n", start.Seq)
+	}
+	fmt.Printf("ok: %d records, seq %d to %d, last hash %s\n", n, start.Seq+1, last.Seq, last.Hash)
+}
@@ -1,52 +1,89 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"strings"
-	"log/syslog"
-	"github.com/ncw/rclone/fs"
-	"github.com/spf13/viper"
-	log "github.com/sirupsen/logrus"
-	lSyslog "github.com/sirupsen/logrus/hooks/syslog"
-	"github.com/hashicorp/go-plugin"
 
-	_ "github.com/ncw/rclone/backend/local"
-	_ "github.com/ncw/rclone/backend/swift"
+	"github.com/hashicorp/go-plugin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 
-	"github.com/worlvlhole/maladapt/pkg/quarantine"
 	"github.com/worlvlhole/maladapt/pkg/plugin"
 	"github.com/worlvlhole/maladapt/pkg/plugin/avscan"
-	
+
+	"github.com/worlvlhole/clamav-plugin/internal/audit"
 	"github.com/worlvlhole/clamav-plugin/internal/clamav"
+	"github.com/worlvlhole/clamav-plugin/internal/cvd"
+	"github.com/worlvlhole/clamav-plugin/internal/hashdb"
+	"github.com/worlvlhole/clamav-plugin/internal/logging"
+	"github.com/worlvlhole/clamav-plugin/internal/remote"
+	"github.com/worlvlhole/clamav-plugin/internal/sealed"
+	"github.com/worlvlhole/clamav-plugin/internal/server"
+	"github.com/worlvlhole/clamav-plugin/internal/tracing"
 )
 
 const (
-	envPrefix     = "MAL"
+	envPrefix = "MAL"
 )
 
-func main() {	
-	log.SetFormatter(&log.JSONFormatter{})
-	hook, err := lSyslog.NewSyslogHook("", "", syslog.LOG_DEBUG, "virustotal")
-	if err != nil {
-		log.Error("could not setup syslog logger")
-	} else {
-		log.AddHook(hook)
-	}
-
-	log.Info("Starting clamav plugin")
+func main() {
 	replacer := strings.NewReplacer(".", "_")
 	viper.SetEnvKeyReplacer(replacer)
 	viper.SetEnvPrefix(envPrefix)
 	viper.AutomaticEnv()
 
+	//Logging
+	logCfg := logging.NewConfigurationFromViper(viper.GetViper())
+	if err := logCfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	closeLog, err := logging.Setup(logCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err := closeLog(); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	log.Info("Starting clamav plugin")
+
+	//Remotes must be registered before any fs is created
+	if err := remote.Register(remote.RemotesFromViper(viper.GetViper())); err != nil {
+		log.Fatal(err)
+	}
+
+	clamCfg := clamav.NewConfigurationFromViper(viper.GetViper())
+	if err := clamCfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
 	avCfg := avscan.NewConfigurationFromViper(viper.GetViper())
-	if err := avCfg.Validate(); err != nil {
+	if err := validate(clamCfg, avCfg); err != nil {
+		log.Fatal(err)
+	}
+
+	//Tracing
+	traceCfg := tracing.NewConfigurationFromViper(viper.GetViper())
+	if err := traceCfg.Validate(); err != nil {
 		log.Fatal(err)
 	}
 
-	theFs, err := fs.NewFs(avCfg.QuarantineConfig.Path)
+	stopTracing, err := tracing.Start(traceCfg)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer func() {
+		if err := stopTracing(); err != nil {
+			log.Error(err)
+		}
+	}()
 
 	//Parser
 	parser := clamav.NewParser()
@@ -54,30 +91,187 @@ func main() {
 	//Verifier
 	verifier := clamav.NewVerifier()
 
-	//Quarantiner
-	quarantine := quarantine.NewQuarantine(avCfg.QuarantineConfig, theFs)
+	//Quarantiner, scans may name any allowed remote as their location
+	var keyring *sealed.Keyring
+	if avCfg.QuarantineConfig.Type == sealed.Type {
+		keyring, err = sealed.KeyringFromViper(viper.GetViper())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	quarantines := remote.NewPool(avCfg.QuarantineConfig, clamCfg.AllowedLocations, keyring)
+	if _, err := quarantines.Quarantine(context.Background(), ""); err != nil {
+		log.Fatal(err)
+	}
+
+	//Signature databases
+	var databases *cvd.Monitor
+	if clamCfg.DatabaseDir != "" {
+		databases = cvd.NewMonitor(clamCfg.DatabaseDir, clamCfg.DatabaseMaxAge, clamCfg.DatabaseStrict)
+		if err := databases.Refresh(); err != nil {
+			log.Error(err)
+		}
+
+		if err := databases.Verify(); err != nil {
+			log.Fatal(err)
+		}
+
+		if databases.Stale() {
+			log.WithField("age", databases.Age().String()).Warn("signature databases are stale")
+		}
+
+		go databases.Watch(context.Background(), clamCfg.DatabaseCheckInterval)
+	}
+
+	//Engine
+	var engine clamav.Engine
+	switch {
+	case clamCfg.Mode == clamav.ModeClamd:
+		clamd, err := clamav.NewClamd(clamCfg.ClamdAddress, clamCfg.ClamdIdleConnections)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer clamd.Close()
+		engine = clamd
+	case clamCfg.Stream:
+		engine = clamav.NewClamscanStream(avCfg.ProgramName, avCfg.ProgramPath, avCfg.ProgramArgs)
+	default:
+		engine = clamav.NewClamscan(
+			avCfg.ProgramName,
+			avCfg.ProgramPath,
+			avCfg.ProgramArgs,
+			avCfg.LocalQuarantineZone,
+			clamCfg.MinFreeBytes,
+		)
+	}
+
+	//Limiter
+	limiter := clamav.NewLimiter(clamCfg.MaxParallelScans, clamCfg.MaxQueueDepth)
+
+	//Results are cached per signature database version,
+	//which is only known when the database dir is set
+	var cache *clamav.Cache
+	if databases != nil {
+		cache = clamav.NewCache(clamCfg.CacheSize, clamCfg.CacheTTL)
+	}
+
+	//Hash signatures
+	var hashes *hashdb.Index
+	if len(clamCfg.HashDatabases)+len(clamCfg.HashAllowlists)+len(clamCfg.HashDenylists) > 0 {
+		hashes = hashdb.NewIndex(clamCfg.HashDatabases, clamCfg.HashAllowlists, clamCfg.HashDenylists)
+		if err := hashes.Load(); err != nil {
+			log.Fatal(err)
+		}
+		log.WithField("hashes", hashes.Len()).Info("hash signatures loaded")
+
+		go func() {
+			if err := hashes.Watch(context.Background()); err != nil {
+				log.Error(err)
+			}
+		}()
+	}
+
+	//Metrics
+	var scanMetrics *clamav.Metrics
+	if clamCfg.MetricsAddress != "" {
+		registry := prometheus.NewRegistry()
+		scanMetrics = clamav.NewMetrics(registry, limiter, databases)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		go func() {
+			log.WithField("address", clamCfg.MetricsAddress).Info("serving metrics")
+			if err := http.ListenAndServe(clamCfg.MetricsAddress, mux); err != nil {
+				log.Error(err)
+			}
+		}()
+	}
+
+	//Audit log
+	auditCfg := audit.NewConfigurationFromViper(viper.GetViper())
+	if err := auditCfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	var auditLog *audit.Log
+	if auditCfg.Path != "" {
+		key, err := audit.ReadKey(auditCfg.KeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		auditLog, err = audit.NewLog(auditCfg, key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer auditLog.Close()
+	}
 
 	//Scanner
-	scanner := avscan.NewScanner(
-		avCfg.ProgramName,
-		avCfg.ProgramPath,
-		avCfg.ProgramArgs,
-		avCfg.LocalQuarantineZone,
-		avCfg.ScanTimeout,
-		parser,
-		verifier,
-		quarantine,
-	)
+	scanner := clamav.NewScanner(clamav.ScannerConfig{
+		Engine:      engine,
+		ScanTimeout: avCfg.ScanTimeout,
+		MaxBytes:    clamCfg.MaxStreamBytes,
+		MaxRatio:    clamCfg.MaxCompressionRatio,
+		Parser:      parser,
+		Verifier:    verifier,
+		Quarantines: quarantines,
+		Databases:   databases,
+		Limiter:     limiter,
+		Cache:       cache,
+		Hashes:      hashes,
+		Metrics:     scanMetrics,
+		Audit:       auditLog,
+	})
+
+	//Health comes from a self-test of the engine, run in the
+	//background as loading the databases can outlast the handshake
+	healthCheck := server.NewHealth(func(ctx context.Context) error {
+		return scanner.SelfTest(ctx, clamCfg.SelfTestSignature)
+	})
+	go func() {
+		if err := healthCheck.Check(context.Background()); err != nil {
+			log.WithField("status", "not_serving").Error(err)
+		} else {
+			log.WithField("status", "serving").Info("self-test passed")
+		}
+		healthCheck.Watch(context.Background(), clamCfg.SelfTestInterval)
+	}()
 
 	pluginMap := map[string]plugin.Plugin{
-		"av_scanner": &plugins.AVScannerGRPCPlugin{Impl: scanner},
+		"av_scanner": &server.GRPCPlugin{Impl: scanner},
 	}
 
 	plugin.Serve(&plugin.ServeConfig{
 		HandshakeConfig: plugins.HandshakeConfig,
-		Plugins: pluginMap,
-		GRPCServer: plugin.DefaultGRPCServer,
+		Plugins:         pluginMap,
+		GRPCServer:      healthCheck.GRPCServer,
 	})
+}
+
+//validate checks the parts of the avscan configuration
+//required by the selected mode. clamd has no use for
+//the clamscan program, and neither clamd nor a streaming
+//clamscan uses the local quarantine zone
+func validate(clamCfg clamav.Configuration, avCfg avscan.Configuration) error {
+	if clamCfg.Mode == clamav.ModeClamscan {
+		if !clamCfg.Stream {
+			return avCfg.Validate()
+		}
+
+		if avCfg.ProgramName == "" {
+			return errors.New("Program name is empty")
+		}
 
+		if avCfg.ProgramPath == "" {
+			return errors.New("Program path is empty")
+		}
+	}
+
+	if avCfg.ScanTimeout == 0 {
+		return errors.New("ScanTimeout is 0")
+	}
 
-}
\ No newline at end of file
+	return avCfg.QuarantineConfig.Validate()
+}
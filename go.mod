@@ -3,23 +3,38 @@ module github.com/worlvlhole/clamav-plugin
 require (
 	github.com/Unknwon/goconfig v0.0.0-20181105214110-56bd8ab18619 // indirect
 	github.com/aws/aws-sdk-go v1.15.74 // indirect
+	github.com/beorn7/perks v0.0.0-20180321164747-3a771d992973 // indirect
+	github.com/dropbox/dropbox-sdk-go-unofficial v4.1.0+incompatible // indirect
+	github.com/fsnotify/fsnotify v1.4.7
+	github.com/golang/protobuf v1.2.0
 	github.com/google/go-querystring v1.0.0 // indirect
+	github.com/google/uuid v1.0.0
 	github.com/gopherjs/gopherjs v0.0.0-20181103185306-d547d1d9531e // indirect
 	github.com/hashicorp/go-plugin v0.0.0-20181030172320-54b6ff97d818
 	github.com/jlaffaye/ftp v0.0.0-20181101112434-47f21d10f0ee // indirect
 	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
 	github.com/ncw/rclone v1.43.1
 	github.com/ncw/swift v1.0.42 // indirect
 	github.com/pkg/sftp v1.8.3 // indirect
+	github.com/prometheus/client_golang v0.9.1
+	github.com/prometheus/client_model v0.0.0-20180712105110-5c3871d89910 // indirect
+	github.com/prometheus/common v0.0.0-20181113130724-41aa239b4cce // indirect
+	github.com/prometheus/procfs v0.0.0-20181005140218-185b4288413d // indirect
 	github.com/rfjakob/eme v0.0.0-20171028163933-2222dbd4ba46 // indirect
 	github.com/sirupsen/logrus v1.2.0
+	github.com/skratchdot/open-golang v0.0.0-20160302144031-75fb7ed4208c // indirect
 	github.com/smartystreets/assertions v0.0.0-20180927180507-b2de0cb4f26d // indirect
 	github.com/smartystreets/goconvey v0.0.0-20181108003508-044398e4856c // indirect
 	github.com/spf13/viper v1.2.1
 	github.com/worlvlhole/maladapt v0.0.0-20181113194227-f25dc0bd2fa8
 	github.com/yunify/qingstor-sdk-go v2.2.15+incompatible // indirect
+	go.opencensus.io v0.18.0
+	golang.org/x/crypto v0.0.0-20181112202954-3d3f9f413869
 	golang.org/x/net v0.0.0-20181114220301-adae6a3d119a // indirect
+	golang.org/x/oauth2 v0.0.0-20180821212333-d2e6202438be // indirect
 	golang.org/x/time v0.0.0-20181108054448-85acf8d2951c // indirect
 	google.golang.org/api v0.0.0-20181113174939-c5e41677a12e // indirect
+	google.golang.org/grpc v1.16.0
 )
 
@@ -0,0 +1,455 @@
+//Package audit keeps a hash chained JSON Lines record of
+//scan outcomes. Every line holds an HMAC of the line before
+//it, so records edited, removed or reordered by anyone without
+//the key break the chain. A head file kept away from the log
+//records where the chain ends, so truncation shows as well
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+//MinKeySize is the shortest key the chain is keyed with
+const MinKeySize = 32
+
+//rotatedFormat is appended to the names of rotated files
+//so they sort in the order they were written
+const rotatedFormat = "20060102T150405.000000000Z"
+
+//maxBatch is the most records committed together
+const maxBatch = 256
+
+//ErrChain a record does not follow the one before it
+var ErrChain = errors.New("audit chain is broken")
+
+//errClosed the log was closed before the record was written
+var errClosed = errors.New("audit log is closed")
+
+//Record is the outcome of one scan
+type Record struct {
+	Seq           uint64            `json:"seq"`
+	Time          time.Time         `json:"time"`
+	Host          string            `json:"host"`
+	ScanID        string            `json:"scan_id"`
+	Filename      string            `json:"filename"`
+	Location      string            `json:"location,omitempty"`
+	Digests       map[string]string `json:"digests,omitempty"` //hex by algorithm
+	Status        string            `json:"status"`
+	Signatures    []string          `json:"signatures,omitempty"`
+	Databases     []string          `json:"databases,omitempty"` //name:version
+	EngineVersion string            `json:"engine_version,omitempty"`
+	Duration      time.Duration     `json:"duration"` //nanoseconds
+	Source        string            `json:"source,omitempty"`
+	Error         string            `json:"error,omitempty"`
+}
+
+//Each Record is written as a line. Record is kept as
+//written so verification hashes the exact bytes
+type line struct {
+	Prev   string          `json:"prev"`
+	Hash   string          `json:"hash"`
+	Record json.RawMessage `json:"record"`
+}
+
+//Head is the end of the chain
+type Head struct {
+	Seq     uint64    `json:"seq"`
+	Hash    string    `json:"hash"`
+	Started time.Time `json:"started"` //when the current file was started
+}
+
+//Configuration defines where the audit log and its head are
+//written, the key of its chain and when it is rotated
+type Configuration struct {
+	Path     string
+	HeadPath string
+	KeyFile  string
+	MaxBytes int64
+	MaxAge   time.Duration
+}
+
+//NewConfigurationFromViper creates a Configuration from the values
+//provided by the viper instance
+func NewConfigurationFromViper(cfg *viper.Viper) Configuration {
+	return NewConfiguration(Configuration{
+		Path:     cfg.GetString("audit.path"),
+		HeadPath: cfg.GetString("audit.head_path"),
+		KeyFile:  cfg.GetString("audit.key_file"),
+		MaxBytes: cfg.GetInt64("audit.max_bytes"),
+		MaxAge:   cfg.GetDuration("audit.max_age"),
+	})
+}
+
+//NewConfiguration creates a new Configuration from the provided values.
+//An empty path disables the audit log, zero limits never rotate
+func NewConfiguration(c Configuration) Configuration {
+	return c
+}
+
+//Validate implements the Validate interface.
+func (c *Configuration) Validate() error {
+	if c.MaxBytes < 0 {
+		return errors.New("audit max bytes is negative")
+	}
+
+	if c.MaxAge < 0 {
+		return errors.New("audit max age is negative")
+	}
+
+	if c.Path == "" {
+		return nil
+	}
+
+	if c.KeyFile == "" {
+		return errors.New("audit key file is empty")
+	}
+
+	if c.HeadPath == "" {
+		return errors.New("audit head path is empty")
+	}
+
+	//whoever can rewrite the log must not be able to move the head
+	if filepath.Dir(filepath.Clean(c.HeadPath)) == filepath.Dir(filepath.Clean(c.Path)) {
+		return errors.New("audit head path must be outside the log's directory")
+	}
+
+	return nil
+}
+
+//ReadKey reads the base64 key the chain is keyed with from the
+//file at path. Anyone holding it can forge the chain, so keep it
+//readable only by the plugin and whoever verifies the log
+func ReadKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if len(key) < MinKeySize {
+		return nil, fmt.Errorf("%s: key is %d bytes, expected at least %d", path, len(key), MinKeySize)
+	}
+	return key, nil
+}
+
+//Log appends chained records to a file, rotating it by size and
+//age. All methods are safe to call on a nil Log
+type Log struct {
+	path     string
+	headPath string
+	key      []byte
+	maxBytes int64
+	maxAge   time.Duration
+	host     string
+	now      func() time.Time
+
+	requests  chan request
+	closing   chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+
+	//only used by the writer
+	file *os.File
+	size int64
+	head Head
+}
+
+//request is a record waiting to be committed
+type request struct {
+	record Record
+	done   chan error
+}
+
+//NewLog opens the audit log in c, continuing the chain keyed
+//with key from its head file. The current file must end with
+//the head's record
+func NewLog(c Configuration, key []byte) (*Log, error) {
+	if len(key) < MinKeySize {
+		return nil, fmt.Errorf("audit key is %d bytes, expected at least %d", len(key), MinKeySize)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		path:     c.Path,
+		headPath: c.HeadPath,
+		key:      key,
+		maxBytes: c.MaxBytes,
+		maxAge:   c.MaxAge,
+		host:     host,
+		now:      time.Now,
+		requests: make(chan request),
+		closing:  make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	go l.run()
+	return l, nil
+}
+
+func (l *Log) open() error {
+	head, err := ReadHead(l.headPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	//the file on disk must be where the head says the chain is
+	last, n, err := l.verifyFile(head)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if n > 0 && last != head {
+		return fmt.Errorf("%w: %s ends at seq %d, head is at seq %d", ErrChain, l.path, last.Seq, head.Seq)
+	}
+	if n == 0 {
+		head.Started = l.now().UTC()
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file, l.size, l.head = file, info.Size(), head
+	return nil
+}
+
+//verifyFile verifies the current file, returning its last
+//record and how many there were
+func (l *Log) verifyFile(head Head) (Head, int, error) {
+	_, last, n, err := VerifyFiles([]string{l.path}, l.key)
+	last.Started = head.Started
+	return last, n, err
+}
+
+//Append chains r to the log and returns once it is on disk.
+//Seq, Time and Host are set by the Log. Records appended
+//concurrently are committed together, sharing one fsync of the
+//log and one update of the head, so a busy plugin pays for
+//them once per batch rather than once per scan
+func (l *Log) Append(r Record) error {
+	if l == nil {
+		return nil
+	}
+
+	req := request{record: r, done: make(chan error, 1)}
+	select {
+	case l.requests <- req:
+	case <-l.closing:
+		return errClosed
+	}
+	return <-req.done
+}
+
+//run commits the records appended until the log is closed
+func (l *Log) run() {
+	defer close(l.stopped)
+
+	for {
+		select {
+		case req := <-l.requests:
+			batch := []request{req}
+		gather:
+			for len(batch) < maxBatch {
+				select {
+				case req := <-l.requests:
+					batch = append(batch, req)
+				default:
+					break gather
+				}
+			}
+			l.commit(batch)
+		case <-l.closing:
+			return
+		}
+	}
+}
+
+//commit writes the batch, then syncs the file and the head once
+func (l *Log) commit(batch []request) {
+	errs := make([]error, len(batch))
+	var written []int
+	for i, req := range batch {
+		if errs[i] = l.write(req.record); errs[i] == nil {
+			written = append(written, i)
+		}
+	}
+
+	if len(written) > 0 {
+		err := l.file.Sync()
+		if err == nil {
+			err = writeHead(l.headPath, l.head)
+		}
+		for _, i := range written {
+			errs[i] = err
+		}
+	}
+
+	for i, req := range batch {
+		req.done <- errs[i]
+	}
+}
+
+//write chains r to the current file, rotating it first when due
+func (l *Log) write(r Record) error {
+	if l.file == nil {
+		return errClosed
+	}
+
+	r.Seq = l.head.Seq + 1
+	r.Time = l.now().UTC()
+	r.Host = l.host
+
+	record, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	hash := chainHash(l.key, l.head.Hash, record)
+	data, err := json.Marshal(line{Prev: l.head.Hash, Hash: hash, Record: record})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if l.rotate(int64(len(data))) {
+		if err := l.rotateFile(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := l.file.Write(data); err != nil {
+		return err
+	}
+	l.size += int64(len(data))
+
+	l.head.Seq, l.head.Hash = r.Seq, hash
+	return nil
+}
+
+//rotate reports whether n more bytes belong in a new file
+func (l *Log) rotate(n int64) bool {
+	if l.size == 0 {
+		return false
+	}
+
+	if l.maxBytes > 0 && l.size+n > l.maxBytes {
+		return true
+	}
+
+	return l.maxAge > 0 && l.now().Sub(l.head.Started) >= l.maxAge
+}
+
+//rotateFile renames the current file aside and starts a new
+//one. The chain carries on, so rotated files verify in order
+func (l *Log) rotateFile() error {
+	//records of the batch already in the file go with it
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	now := l.now().UTC()
+	if err := os.Rename(l.path, l.path+"."+now.Format(rotatedFormat)); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	l.file, l.size, l.head.Started = file, 0, now
+	return nil
+}
+
+//Close waits for the records being committed and closes the
+//current file. Records appended afterwards fail
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closing)
+		<-l.stopped
+		if l.file != nil {
+			err = l.file.Close()
+			l.file = nil
+		}
+	})
+	return err
+}
+
+//chainHash is the HMAC of a record following prev
+func chainHash(key []byte, prev string, record []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(prev))
+	h.Write([]byte{'\n'})
+	h.Write(record)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//ReadHead reads the head file at path
+func ReadHead(path string) (Head, error) {
+	var head Head
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return head, err
+	}
+
+	if err := json.Unmarshal(data, &head); err != nil {
+		return head, fmt.Errorf("%s: %w", path, err)
+	}
+	return head, nil
+}
+
+//writeHead replaces the head file, never leaving a partial one
+func writeHead(path string, head Head) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
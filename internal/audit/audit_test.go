@@ -0,0 +1,340 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testKey = bytes.Repeat([]byte{7}, MinKeySize)
+
+//config keeps the head of the log at path in its parent directory
+func config(path string, maxBytes int64, maxAge time.Duration) Configuration {
+	return Configuration{
+		Path:     path,
+		HeadPath: filepath.Join(filepath.Dir(filepath.Dir(path)), "audit.head"),
+		MaxBytes: maxBytes,
+		MaxAge:   maxAge,
+	}
+}
+
+func tempLog(t *testing.T, maxBytes int64, maxAge time.Duration) (*Log, string, func()) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "log"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "log", "audit.jsonl")
+	l, err := NewLog(config(path, maxBytes, maxAge), testKey)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return l, path, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func appendRecords(t *testing.T, l *Log, n int) {
+	for i := 0; i < n; i++ {
+		err := l.Append(Record{
+			ScanID:     "id",
+			Filename:   "file",
+			Status:     "infected",
+			Signatures: []string{"Eicar-Test-Signature"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//files returns the rotated files oldest first, then the current one
+func files(t *testing.T, path string) []string {
+	rotated, err := filepath.Glob(path + ".2*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(rotated)
+	return append(rotated, path)
+}
+
+func TestLogVerify(t *testing.T) {
+	l, path, cleanup := tempLog(t, 0, 0)
+	defer cleanup()
+
+	appendRecords(t, l, 3)
+
+	start, last, n, err := VerifyFiles([]string{path}, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start.Seq != 0 || last.Seq != 3 || n != 3 {
+		t.Fatalf("Expected seq 1 to 3, Received %d to %d in %d records", start.Seq+1, last.Seq, n)
+	}
+
+	head, err := ReadHead(config(path, 0, 0).HeadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Seq != last.Seq || head.Hash != last.Hash {
+		t.Fatalf("Expected head %+v, Received %+v", last, head)
+	}
+
+	//reopening continues the chain
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = NewLog(config(path, 0, 0), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	appendRecords(t, l, 1)
+
+	if _, last, _, err := VerifyFiles([]string{path}, testKey); err != nil || last.Seq != 4 {
+		t.Fatalf("Expected a chain to seq 4, Received %d, %v", last.Seq, err)
+	}
+}
+
+func TestLogTampered(t *testing.T) {
+	l, path, cleanup := tempLog(t, 0, 0)
+	defer cleanup()
+
+	appendRecords(t, l, 3)
+	l.Close()
+
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(original, []byte("\n"))
+
+	tests := map[string][]byte{
+		"edited":    bytes.Replace(original, []byte("infected"), []byte("clean"), 1),
+		"removed":   bytes.Join([][]byte{lines[0], lines[2]}, nil),
+		"reordered": bytes.Join([][]byte{lines[1], lines[0], lines[2]}, nil),
+		"cut short": original[:len(original)-10],
+	}
+
+	for name, data := range tests {
+		if err := ioutil.WriteFile(path, data, 0640); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, _, err := VerifyFiles([]string{path}, testKey); !errors.Is(err, ErrChain) {
+			t.Fatalf("%s: Expected %v, Received %v", name, ErrChain, err)
+		}
+	}
+
+	//the chain cannot be rewritten without the key
+	if err := ioutil.WriteFile(path, original, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := VerifyFiles([]string{path}, bytes.Repeat([]byte{8}, MinKeySize)); !errors.Is(err, ErrChain) {
+		t.Fatalf("Expected %v with another key, Received %v", ErrChain, err)
+	}
+
+	//dropping whole records from the end only shows against the head
+	if err := ioutil.WriteFile(path, bytes.Join(lines[:2], nil), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := VerifyFiles([]string{path}, testKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLog(config(path, 0, 0), testKey); !errors.Is(err, ErrChain) {
+		t.Fatalf("Expected %v reopening a truncated log, Received %v", ErrChain, err)
+	}
+}
+
+func TestLogRotate(t *testing.T) {
+	l, path, cleanup := tempLog(t, 1024, 0)
+	defer cleanup()
+
+	appendRecords(t, l, 12)
+
+	paths := files(t, path)
+	if len(paths) < 3 {
+		t.Fatalf("Expected the log to rotate by size, Received %v", paths)
+	}
+
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 1024 {
+			t.Fatalf("Expected at most 1024 bytes, %s has %d", p, info.Size())
+		}
+	}
+
+	start, last, n, err := VerifyFiles(paths, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start.Seq != 0 || last.Seq != 12 || n != 12 {
+		t.Fatalf("Expected 12 chained records, Received %d ending at %d", n, last.Seq)
+	}
+
+	//without the oldest file the chain starts later
+	if start, _, _, err := VerifyFiles(paths[1:], testKey); err != nil || start.Seq == 0 {
+		t.Fatalf("Expected the chain to start after seq 0, Received %d, %v", start.Seq, err)
+	}
+
+	if _, _, _, err := VerifyFiles(append([]string{paths[0]}, paths[2:]...), testKey); !errors.Is(err, ErrChain) {
+		t.Fatalf("Expected %v without a middle file, Received %v", ErrChain, err)
+	}
+}
+
+func TestLogRotateAge(t *testing.T) {
+	l, path, cleanup := tempLog(t, 0, time.Hour)
+	defer cleanup()
+
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	l.head.Started = now
+
+	appendRecords(t, l, 2)
+	now = now.Add(time.Hour)
+	appendRecords(t, l, 1)
+
+	paths := files(t, path)
+	if len(paths) != 2 {
+		t.Fatalf("Expected the log to rotate by age, Received %v", paths)
+	}
+
+	if _, last, n, err := VerifyFiles(paths, testKey); err != nil || n != 3 || last.Seq != 3 {
+		t.Fatalf("Expected 3 chained records, Received %d ending at %d, %v", n, last.Seq, err)
+	}
+}
+
+func TestLogConcurrent(t *testing.T) {
+	l, path, cleanup := tempLog(t, 2048, 0)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if err := l.Append(Record{ScanID: "id", Filename: "file", Status: "clean"}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, last, n, err := VerifyFiles(files(t, path), testKey)
+	if err != nil || n != 200 {
+		t.Fatalf("Expected 200 chained records, Received %d, %v", n, err)
+	}
+
+	head, err := ReadHead(config(path, 0, 0).HeadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Seq != last.Seq || head.Hash != last.Hash {
+		t.Fatalf("Expected head %+v, Received %+v", last, head)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(Record{ScanID: "id"}); err == nil {
+		t.Fatal("Expected appending to a closed log to fail")
+	}
+}
+
+func TestConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "audit.key")
+	if err := ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(testKey)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	shortKey := filepath.Join(dir, "short.key")
+	if err := ioutil.WriteFile(shortKey, []byte(base64.StdEncoding.EncodeToString(testKey[:16])), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cfg   Configuration
+		valid bool
+	}{
+		{Configuration{}, true},
+		{Configuration{Path: "/var/log/audit/audit.jsonl", HeadPath: "/var/lib/audit/audit.head", KeyFile: keyFile}, true},
+		{Configuration{Path: "/var/log/audit/audit.jsonl", HeadPath: "/var/log/audit/audit.head", KeyFile: keyFile}, false},
+		{Configuration{Path: "/var/log/audit/audit.jsonl", KeyFile: keyFile}, false},
+		{Configuration{Path: "/var/log/audit/audit.jsonl", HeadPath: "/var/lib/audit/audit.head"}, false},
+		{Configuration{MaxBytes: -1}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.cfg.Validate(); (err == nil) != test.valid {
+			t.Fatalf("Expected valid %v, Received %v for %+v", test.valid, err, test.cfg)
+		}
+	}
+
+	if key, err := ReadKey(keyFile); err != nil || !bytes.Equal(key, testKey) {
+		t.Fatalf("Expected the key, Received %x, %v", key, err)
+	}
+	if _, err := ReadKey(shortKey); err == nil {
+		t.Fatal("Expected a short key to be rejected")
+	}
+}
+
+//BenchmarkLogAppend measures concurrent appends, which share
+//the fsync and head update of their batch
+func BenchmarkLogAppend(b *testing.B) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "log"), 0755); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, parallelism := range []int{1, 16} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			path := filepath.Join(dir, "log", fmt.Sprintf("audit-%d-%d.jsonl", parallelism, b.N))
+			cfg := config(path, 0, 0)
+			cfg.HeadPath = filepath.Join(dir, filepath.Base(path)+".head")
+			l, err := NewLog(cfg, testKey)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer l.Close()
+
+			b.SetParallelism(parallelism)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := l.Append(Record{ScanID: "id", Filename: "file", Status: "clean"}); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
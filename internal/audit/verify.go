@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+//maxLine is the longest record read back
+const maxLine = 1 << 20
+
+//firstPrev returns where the chain in r starts, nil when r is empty
+func firstPrev(r io.Reader) (*Head, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLine)
+	if !scanner.Scan() {
+		return nil, scanner.Err()
+	}
+
+	var first line
+	if err := json.Unmarshal(scanner.Bytes(), &first); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrChain, err)
+	}
+
+	var record Record
+	if err := json.Unmarshal(first.Record, &record); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrChain, err)
+	}
+
+	return &Head{Seq: record.Seq - 1, Hash: first.Prev}, nil
+}
+
+//Verify checks that every line of r is intact and chained with
+//key to the one before it, starting from start. It returns the
+//last record read and how many there were. A last line without
+//its newline was cut short and fails verification
+func Verify(r io.Reader, start Head, key []byte) (Head, int, error) {
+	head := start
+	reader := bufio.NewReaderSize(r, 64<<10)
+
+	var n int
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(data) == 0 {
+			return head, n, nil
+		}
+		if err == io.EOF {
+			return head, n, fmt.Errorf("%w: record %d is truncated", ErrChain, head.Seq+1)
+		}
+		if err != nil {
+			return head, n, err
+		}
+		if len(data) > maxLine {
+			return head, n, fmt.Errorf("%w: record %d is too long", ErrChain, head.Seq+1)
+		}
+
+		next, err := verifyLine(bytes.TrimSuffix(data, []byte{'\n'}), head, key)
+		if err != nil {
+			return head, n, err
+		}
+		head = next
+		n++
+	}
+}
+
+//verifyLine checks one line follows head and returns the new head
+func verifyLine(data []byte, head Head, key []byte) (Head, error) {
+	var l line
+	if err := json.Unmarshal(data, &l); err != nil {
+		return head, fmt.Errorf("%w: record %d: %v", ErrChain, head.Seq+1, err)
+	}
+
+	var record Record
+	if err := json.Unmarshal(l.Record, &record); err != nil {
+		return head, fmt.Errorf("%w: record %d: %v", ErrChain, head.Seq+1, err)
+	}
+
+	switch {
+	case record.Seq != head.Seq+1:
+		return head, fmt.Errorf("%w: expected seq %d, found %d", ErrChain, head.Seq+1, record.Seq)
+	case l.Prev != head.Hash:
+		return head, fmt.Errorf("%w: seq %d does not follow seq %d", ErrChain, record.Seq, head.Seq)
+	case !hmac.Equal([]byte(l.Hash), []byte(chainHash(key, l.Prev, l.Record))):
+		return head, fmt.Errorf("%w: seq %d was modified", ErrChain, record.Seq)
+	}
+
+	return Head{Seq: record.Seq, Hash: l.Hash, Started: head.Started}, nil
+}
+
+//VerifyFiles verifies the files in the order they were written,
+//oldest rotated file first, as one chain keyed with key. It
+//returns where the chain starts, which is seq 0 unless older
+//files are missing, the last record and the number of records.
+//Records cut from the end only show against the head file
+func VerifyFiles(paths []string, key []byte) (start Head, last Head, n int, err error) {
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return start, last, n, err
+		}
+
+		if n == 0 {
+			first, err := firstPrev(file)
+			if err != nil {
+				file.Close()
+				return start, last, n, fmt.Errorf("%s: %w", path, err)
+			}
+			if first != nil {
+				start, last = *first, *first
+			}
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				file.Close()
+				return start, last, n, err
+			}
+		}
+
+		var count int
+		last, count, err = Verify(file, last, key)
+		file.Close()
+		n += count
+		if err != nil {
+			return start, last, n, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if start.Seq == 0 && start.Hash != "" {
+		return start, last, n, fmt.Errorf("%w: seq 1 does not start the chain", ErrChain)
+	}
+
+	return start, last, n, nil
+}
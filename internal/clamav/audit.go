@@ -0,0 +1,70 @@
+package clamav
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/worlvlhole/maladapt/pkg/ipc"
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+
+	"github.com/worlvlhole/clamav-plugin/internal/audit"
+)
+
+//ErrAudit the outcome of a scan could not be recorded in the
+//audit log, so it is not handed out unrecorded
+var ErrAudit = errors.New("scan outcome could not be audited")
+
+//Sources of a verdict recorded in the audit log
+//besides MatchSource
+const (
+	sourceCache  = "cache"
+	sourceShared = "shared"
+)
+
+//auditRecord describes how a scan was answered
+func auditRecord(scan ipc.Scan, res plugins.Result, err error, duration time.Duration) audit.Record {
+	record := audit.Record{
+		ScanID:   scan.ID.String(),
+		Filename: scan.Filename,
+		Location: scan.Location,
+		Digests:  map[string]string{},
+		Status:   string(StatusFailed),
+		Duration: duration,
+	}
+
+	for _, digest := range scan.Digests {
+		record.Digests[digest.Algorithm] = hex.EncodeToString(digest.Hash)
+	}
+
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+
+	report, ok := reportOf(res)
+	if !ok {
+		return record
+	}
+
+	record.Status = string(report.Status)
+	record.EngineVersion = report.EngineVersion
+	for _, detection := range report.Detections {
+		record.Signatures = append(record.Signatures, detection.Signature)
+	}
+	for _, header := range report.Databases {
+		record.Databases = append(record.Databases, header.Name+":"+strconv.Itoa(header.Version))
+	}
+
+	switch {
+	case report.MatchSource != "":
+		record.Source = report.MatchSource
+	case report.Cached:
+		record.Source = sourceCache
+	case report.Shared:
+		record.Source = sourceShared
+	}
+
+	return record
+}
@@ -0,0 +1,108 @@
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/worlvlhole/maladapt/pkg/ipc"
+
+	"github.com/worlvlhole/clamav-plugin/internal/audit"
+)
+
+var auditKey = bytes.Repeat([]byte{7}, audit.MinKeySize)
+
+func TestScannerAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := "#!/bin/sh\nif grep -q EICAR \"$2\"; then echo \"$2: Eicar-Test-Signature FOUND\"; exit 1; fi\necho \"$2: OK\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "clamscan"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "audit.jsonl")
+	log, err := audit.NewLog(audit.Configuration{Path: path, HeadPath: path + ".head"}, auditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	quarantine := memQuarantine{"eicar": []byte(eicar)}
+	engine := NewClamscan("clamscan", dir, []string{"--no-summary"}, dir, 0)
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine, Audit: log})
+
+	id := uuid.New()
+	if _, err := scanner.Scan(ipc.Scan{ID: id, Filename: "eicar"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "missing"}); err == nil {
+		t.Fatal("Expected an error scanning a missing file")
+	}
+
+	if _, last, n, err := audit.VerifyFiles([]string{path}, auditKey); err != nil || n != 2 || last.Seq != 2 {
+		t.Fatalf("Expected 2 chained records, Received %d, %v", n, err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []audit.Record
+	lines := bufio.NewScanner(file)
+	for lines.Scan() {
+		var l struct{ Record audit.Record }
+		if err := json.Unmarshal(lines.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, l.Record)
+	}
+
+	infected := records[0]
+	if infected.ScanID != id.String() || infected.Status != string(StatusInfected) || infected.Host == "" {
+		t.Fatalf("Expected an infected record for %s, Received %+v", id, infected)
+	}
+	if len(infected.Signatures) != 1 || infected.Signatures[0] != "Eicar-Test-Signature" {
+		t.Fatalf("Expected the Eicar-Test-Signature, Received %v", infected.Signatures)
+	}
+
+	failed := records[1]
+	if failed.Status != string(StatusFailed) || failed.Error == "" {
+		t.Fatalf("Expected a failed record with its error, Received %+v", failed)
+	}
+}
+
+func TestScannerAuditFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	log, err := audit.NewLog(audit.Configuration{Path: path, HeadPath: path + ".head"}, auditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a closed log fails every append
+	log.Close()
+
+	quarantine := memQuarantine{"file": []byte("content")}
+	scanner := NewScanner(ScannerConfig{Engine: fakeEngine{output: []byte("stream: OK")}, ScanTimeout: time.Minute, Quarantines: quarantine, Audit: log})
+
+	if _, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "file"}); !errors.Is(err, ErrAudit) {
+		t.Fatalf("Expected %v, Received %v", ErrAudit, err)
+	}
+}
@@ -0,0 +1,157 @@
+package clamav
+
+import (
+	"container/list"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/worlvlhole/maladapt/pkg/digests"
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+
+	"github.com/worlvlhole/clamav-plugin/internal/cvd"
+)
+
+//Cache holds scan results keyed on the SHA-256 digest of the
+//scanned content. Results are only valid for the signature
+//databases they were scanned with, so entries are dropped as
+//soon as the database versions change
+type Cache struct {
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	version string                   //database versions of every entry
+	entries map[string]*list.Element //digest to entry
+	order   *list.List               //most recently used first
+}
+
+type cacheEntry struct {
+	digest  string
+	result  plugins.Result
+	expires time.Time
+}
+
+//NewCache creates a Cache holding at most maxEntries results
+//for at most ttl each. A ttl of 0 keeps results until the
+//databases change or they are evicted. With no entries the
+//Cache is nil, which never holds results
+func NewCache(maxEntries int, ttl time.Duration) *Cache {
+	if maxEntries <= 0 {
+		return nil
+	}
+
+	return &Cache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		now:        time.Now,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+//Get returns the result cached for digest under version.
+//A nil Cache never holds results
+func (c *Cache) Get(digest, version string) (plugins.Result, bool) {
+	if c == nil || digest == "" || version == "" {
+		return plugins.Result{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidate(version)
+	elem, ok := c.entries[digest]
+	if !ok {
+		return plugins.Result{}, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && c.now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, digest)
+		return plugins.Result{}, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.result, true
+}
+
+//Put caches res for digest under version, evicting the
+//least recently used result when the cache is full. Callers
+//must only put results scanned with the current databases
+func (c *Cache) Put(digest, version string, res plugins.Result) {
+	if c == nil || digest == "" || version == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidate(version)
+	entry := &cacheEntry{digest: digest, result: res}
+	if c.ttl > 0 {
+		entry.expires = c.now().Add(c.ttl)
+	}
+
+	if elem, ok := c.entries[digest]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[digest] = c.order.PushFront(entry)
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).digest)
+	}
+}
+
+//Len returns the number of cached results
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+//invalidate drops every entry when version differs from
+//the version of the cached entries. c.mu must be held
+func (c *Cache) invalidate(version string) {
+	if version == c.version {
+		return
+	}
+
+	c.version = version
+	c.entries = map[string]*list.Element{}
+	c.order.Init()
+}
+
+//databaseVersion identifies the signature databases in use,
+//e.g. "bytecode:333,daily:27204,main:62". It is empty when
+//the databases are unknown and results cannot be cached
+func databaseVersion(headers []cvd.Header) string {
+	versions := make([]string, len(headers))
+	for i, header := range headers {
+		versions[i] = fmt.Sprintf("%s:%d", header.Name, header.Version)
+	}
+	return strings.Join(versions, ",")
+}
+
+//sha256Digest returns the hex SHA-256 digest of the scan, or
+//an empty string when the request did not carry one
+func sha256Digest(scanDigests []digests.Digest) string {
+	for _, digest := range scanDigests {
+		if strings.EqualFold(digest.Algorithm, digests.SHA256) && len(digest.Hash) > 0 {
+			return hex.EncodeToString(digest.Hash)
+		}
+	}
+	return ""
+}
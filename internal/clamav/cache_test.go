@@ -0,0 +1,270 @@
+package clamav
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/worlvlhole/maladapt/pkg/digests"
+	"github.com/worlvlhole/maladapt/pkg/ipc"
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+
+	"github.com/worlvlhole/clamav-plugin/internal/cvd"
+)
+
+//countingEngine counts scans and holds
+//each one until gate is closed
+type countingEngine struct {
+	scans int32
+	gate  chan struct{}
+}
+
+func (c *countingEngine) Scan(ctx context.Context, r io.Reader) ([]byte, error) {
+	atomic.AddInt32(&c.scans, 1)
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return nil, err
+	}
+
+	if c.gate != nil {
+		<-c.gate
+	}
+	return []byte("stream: OK"), nil
+}
+
+func writeDaily(t *testing.T, dir string, version int) {
+	fields := "ClamAV-VDB:05 Mar 2024 04-23 -0500:" + strconv.Itoa(version) + ":2053179:90:md5:dsig:raynman:1709630580"
+	data := []byte(fields + strings.Repeat(" ", cvd.HeaderSize-len(fields)))
+	if err := ioutil.WriteFile(filepath.Join(dir, "daily.cld"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func scanDigest(content []byte) ipc.Scan {
+	sum := sha256.Sum256(content)
+	return ipc.Scan{
+		ID:       uuid.New(),
+		Filename: "file",
+		Digests:  []digests.Digest{{Algorithm: digests.SHA256, Hash: sum[:]}},
+	}
+}
+
+func TestCache(t *testing.T) {
+	cache := NewCache(2, time.Hour)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	res := plugins.Result{Type: plugins.VirusScan}
+	cache.Put("a", "daily:1", res)
+	cache.Put("b", "daily:1", res)
+	if _, ok := cache.Get("a", "daily:1"); !ok {
+		t.Fatal("Expected a to be cached")
+	}
+
+	//b is the least recently used
+	cache.Put("c", "daily:1", res)
+	if _, ok := cache.Get("b", "daily:1"); ok {
+		t.Fatal("Expected b to be evicted")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok := cache.Get("a", "daily:1"); ok {
+		t.Fatal("Expected a to expire")
+	}
+
+	cache.Put("a", "daily:1", res)
+	if _, ok := cache.Get("a", "daily:2"); ok || cache.Len() != 0 {
+		t.Fatalf("Expected a database update to empty the cache, %d entries", cache.Len())
+	}
+
+	cache.Put("a", "", res)
+	if _, ok := cache.Get("a", ""); ok {
+		t.Fatal("Expected results without database versions not to be cached")
+	}
+}
+
+func TestScannerCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "databases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeDaily(t, dir, 27204)
+	monitor := cvd.NewMonitor(dir, 0, false)
+	if err := monitor.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("content")
+	quarantine := memQuarantine{"file": content}
+	engine := &countingEngine{}
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine, Databases: monitor, Cache: NewCache(16, 0)})
+
+	for i := 0; i < 2; i++ {
+		res, err := scanner.Scan(scanDigest(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cached := res.Details.(plugins.VirusScanResult).Context.(Report).Cached; cached != (i == 1) {
+			t.Fatalf("Expected scan %d cached %t, Received %t", i, i == 1, cached)
+		}
+	}
+
+	if scans := atomic.LoadInt32(&engine.scans); scans != 1 {
+		t.Fatalf("Expected 1 scan, Received %d", scans)
+	}
+
+	//a new daily invalidates the cached result
+	writeDaily(t, dir, 27205)
+	if err := monitor.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := scanner.Scan(scanDigest(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	if scans := atomic.LoadInt32(&engine.scans); scans != 2 {
+		t.Fatalf("Expected 2 scans, Received %d", scans)
+	}
+}
+
+func TestScannerSharedScan(t *testing.T) {
+	content := []byte("content")
+	quarantine := memQuarantine{"file": content}
+	engine := &countingEngine{gate: make(chan struct{})}
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine})
+
+	const requests = 5
+	var wg sync.WaitGroup
+	var shared int32
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := scanner.Scan(scanDigest(content))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			report := res.Details.(plugins.VirusScanResult).Context.(Report)
+			if report.Status != StatusClean {
+				t.Errorf("Expected %s, Received %s", StatusClean, report.Status)
+			}
+			if report.Shared {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+
+	//give every request time to join the scan in progress
+	for atomic.LoadInt32(&engine.scans) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(engine.gate)
+	wg.Wait()
+
+	if scans := atomic.LoadInt32(&engine.scans); scans != 1 {
+		t.Fatalf("Expected 1 scan, Received %d", scans)
+	}
+
+	if shared != requests-1 {
+		t.Fatalf("Expected %d shared results, Received %d", requests-1, shared)
+	}
+}
+
+//gatedQuarantine holds opens of one file until gate
+//is closed, then fails them as if it did not exist
+type gatedQuarantine struct {
+	memQuarantine
+	missing string
+	opened  chan struct{}
+	gate    chan struct{}
+}
+
+func (g gatedQuarantine) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	if filename != g.missing {
+		return g.memQuarantine.OpenFile(ctx, filename)
+	}
+
+	close(g.opened)
+	<-g.gate
+	return nil, os.ErrNotExist
+}
+
+func (g gatedQuarantine) Quarantine(ctx context.Context, location string) (Quarantine, error) {
+	return g, nil
+}
+
+func TestScannerSharedScanError(t *testing.T) {
+	content := []byte("content")
+	quarantine := gatedQuarantine{
+		memQuarantine: memQuarantine{"file": content},
+		missing:       "missing",
+		opened:        make(chan struct{}),
+		gate:          make(chan struct{}),
+	}
+	engine := &countingEngine{}
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine})
+
+	//the first request names a file that is not there
+	leader := scanDigest(content)
+	leader.Filename = "missing"
+	failed := make(chan error, 1)
+	go func() {
+		_, err := scanner.Scan(leader)
+		failed <- err
+	}()
+	<-quarantine.opened
+
+	cancelled := make(chan error, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	go func() {
+		_, err := scanner.ScanContext(ctx, scanDigest(content))
+		cancelled <- err
+	}()
+
+	waiter := make(chan error, 1)
+	go func() {
+		res, err := scanner.Scan(scanDigest(content))
+		if err == nil && res.Details.(plugins.VirusScanResult).Context.(Report).Status != StatusClean {
+			err = errors.New("expected a clean verdict")
+		}
+		waiter <- err
+	}()
+
+	if err := <-cancelled; err != ErrCancelled {
+		t.Fatalf("Expected %v for a waiter whose context is done, Received %v", ErrCancelled, err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(quarantine.gate)
+
+	if err := <-failed; !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected %v, Received %v", os.ErrNotExist, err)
+	}
+
+	//the waiter scans its own file rather than share the error
+	if err := <-waiter; err != nil {
+		t.Fatal(err)
+	}
+
+	if scans := atomic.LoadInt32(&engine.scans); scans != 1 {
+		t.Fatalf("Expected 1 scan, Received %d", scans)
+	}
+}
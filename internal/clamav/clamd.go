@@ -0,0 +1,305 @@
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/worlvlhole/clamav-plugin/internal/logging"
+)
+
+const (
+	//size of each INSTREAM chunk sent to clamd
+	chunkSize = 64 * 1024
+	//how long to wait on a connection health check
+	pingTimeout = 5 * time.Second
+)
+
+var (
+	errPong = errors.New("unexpected reply to clamd PING")
+)
+
+//Clamd streams content to a running clamd daemon using the
+//INSTREAM command. Connections are kept open in IDSESSION mode
+//and reused between scans so the signatures are only ever
+//loaded once, by clamd.
+type Clamd struct {
+	network string
+	address string
+	idle    chan *session
+}
+
+//session is a single connection to clamd in IDSESSION mode
+type session struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+//NewClamd creates a clamd client for the given address. At most
+//idleConnections sessions are kept open between scans
+func NewClamd(address string, idleConnections int) (*Clamd, error) {
+	network, addr, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Clamd{
+		network: network,
+		address: addr,
+		idle:    make(chan *session, idleConnections),
+	}, nil
+}
+
+//Ping checks that clamd is reachable and responding
+func (c *Clamd) Ping(ctx context.Context) error {
+	s, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	c.release(ctx, s)
+	return nil
+}
+
+//Scan streams the contents of r to clamd and returns
+//its reply, e.g. "stream: Eicar-Test-Signature FOUND"
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) ([]byte, error) {
+	s, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := s.watch(ctx)
+	reply, err := s.instream(r)
+	stop()
+	if err != nil {
+		s.close(ctx)
+		return nil, contextErr(ctx, err)
+	}
+
+	//clamd ends the session after a failed command
+	if strings.HasSuffix(reply, "ERROR") {
+		s.close(ctx)
+	} else {
+		c.release(ctx, s)
+	}
+
+	return []byte(reply), nil
+}
+
+//Close ends all idle sessions
+func (c *Clamd) Close() error {
+	for {
+		select {
+		case s := <-c.idle:
+			s.end(context.Background())
+		default:
+			return nil
+		}
+	}
+}
+
+//acquire returns a live session, reusing an idle one if possible
+func (c *Clamd) acquire(ctx context.Context) (*session, error) {
+	logger := logging.FromContext(ctx).WithFields(log.Fields{"func": "acquire"})
+
+	for {
+		select {
+		case s := <-c.idle:
+			if err := s.ping(); err != nil {
+				logger.Debug("discarding stale clamd session: ", err)
+				s.close(ctx)
+				continue
+			}
+			return s, nil
+		default:
+			return c.dial(ctx)
+		}
+	}
+}
+
+//release returns a session to the idle pool, or ends
+//it if the pool is already full
+func (c *Clamd) release(ctx context.Context, s *session) {
+	select {
+	case c.idle <- s:
+	default:
+		s.end(ctx)
+	}
+}
+
+func (c *Clamd) dial(ctx context.Context) (*session, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &session{conn: conn, reader: bufio.NewReader(conn)}
+	if err := s.command("IDSESSION"); err != nil {
+		s.close(ctx)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+//contextErr returns the error of a done ctx in place of err.
+//The conn deadline may fire just before ctx's own timer does
+func contextErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var netErr net.Error
+	if deadline, ok := ctx.Deadline(); ok && errors.As(err, &netErr) &&
+		netErr.Timeout() && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+
+	return err
+}
+
+//watch unblocks any pending I/O on the session once ctx is
+//done. The returned func must be called when the I/O completes
+func (s *session) watch(ctx context.Context) func() {
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetDeadline(deadline)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		s.conn.SetDeadline(time.Time{})
+	}
+}
+
+func (s *session) command(cmd string) error {
+	_, err := s.conn.Write([]byte("z" + cmd + "\x00"))
+	return err
+}
+
+//reply reads a single NUL terminated reply and strips the
+//request id prefix clamd adds in IDSESSION mode
+func (s *session) reply() (string, error) {
+	reply, err := s.reader.ReadString(0)
+	if err != nil {
+		return "", err
+	}
+
+	reply = strings.TrimSuffix(reply, "\x00")
+	if i := strings.Index(reply, ": "); i > 0 {
+		if _, err := fmt.Sscanf(reply[:i], "%d", new(int)); err == nil {
+			reply = reply[i+2:]
+		}
+	}
+
+	return reply, nil
+}
+
+func (s *session) ping() error {
+	s.conn.SetDeadline(time.Now().Add(pingTimeout))
+	defer s.conn.SetDeadline(time.Time{})
+
+	if err := s.command("PING"); err != nil {
+		return err
+	}
+
+	reply, err := s.reply()
+	if err != nil {
+		return err
+	}
+
+	if reply != "PONG" {
+		return errPong
+	}
+
+	return nil
+}
+
+func (s *session) instream(r io.Reader) (string, error) {
+	if err := s.command("INSTREAM"); err != nil {
+		return "", err
+	}
+
+	w := bufio.NewWriterSize(s.conn, chunkSize+4)
+	buf := make([]byte, chunkSize)
+	size := make([]byte, 4)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := w.Write(size); err != nil {
+				return s.streamErr(err)
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return s.streamErr(err)
+			}
+		}
+
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return "", rerr
+		}
+	}
+
+	//zero length chunk terminates the stream
+	if _, err := w.Write(bytes.Repeat([]byte{0}, 4)); err != nil {
+		return s.streamErr(err)
+	}
+
+	if err := w.Flush(); err != nil {
+		return s.streamErr(err)
+	}
+
+	return s.reply()
+}
+
+//streamErr returns clamd's reply in place of a failed write.
+//Once a stream passes StreamMaxLength clamd replies with
+//"INSTREAM size limit exceeded. ERROR" and closes the
+//connection, failing the writes that follow
+func (s *session) streamErr(err error) (string, error) {
+	//the deadline or cancellation, not clamd, ended the stream
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "", err
+	}
+
+	if reply, rerr := s.reply(); rerr == nil {
+		return reply, nil
+	}
+
+	return "", err
+}
+
+//end politely closes the session
+func (s *session) end(ctx context.Context) {
+	s.conn.SetDeadline(time.Now().Add(pingTimeout))
+	s.command("END")
+	s.close(ctx)
+}
+
+func (s *session) close(ctx context.Context) {
+	if err := s.conn.Close(); err != nil {
+		logging.FromContext(ctx).WithFields(log.Fields{"func": "close"}).Error(err)
+	}
+}
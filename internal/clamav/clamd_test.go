@@ -0,0 +1,397 @@
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/worlvlhole/maladapt/pkg/ipc"
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+)
+
+//fakeClamd is an in-process clamd speaking enough of the
+//protocol to exercise the client
+type fakeClamd struct {
+	listener  net.Listener
+	mu        sync.Mutex
+	accepted  int
+	streamed  [][]byte
+	maxLength int //StreamMaxLength, 0 is unlimited
+}
+
+//errStreamMaxLength a stream is longer than StreamMaxLength
+var errStreamMaxLength = errors.New("INSTREAM size limit exceeded")
+
+func newFakeClamd(t *testing.T, network, address string) *fakeClamd {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeClamd{listener: l}
+	go f.serve()
+	return f
+}
+
+func (f *fakeClamd) address() string {
+	return f.listener.Addr().Network() + "://" + f.listener.Addr().String()
+}
+
+func (f *fakeClamd) connections() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.accepted
+}
+
+func (f *fakeClamd) close() {
+	f.listener.Close()
+}
+
+func (f *fakeClamd) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.accepted++
+		f.mu.Unlock()
+
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	session, id := false, 0
+	reply := func(msg string) {
+		if session {
+			msg = fmt.Sprintf("%d: %s", id, msg)
+		}
+		conn.Write([]byte(msg + "\x00"))
+	}
+
+	for {
+		cmd, err := r.ReadString(0)
+		if err != nil {
+			return
+		}
+		id++
+
+		switch strings.TrimSuffix(cmd, "\x00") {
+		case "zIDSESSION":
+			session, id = true, 0
+		case "zEND":
+			return
+		case "zPING":
+			reply("PONG")
+		case "zINSTREAM":
+			f.mu.Lock()
+			maxLength := f.maxLength
+			f.mu.Unlock()
+
+			//like clamd, reply and hang up mid stream
+			data, err := readChunks(r, maxLength)
+			if err == errStreamMaxLength {
+				reply("INSTREAM size limit exceeded. ERROR")
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			f.mu.Lock()
+			f.streamed = append(f.streamed, data)
+			f.mu.Unlock()
+
+			if bytes.Contains(data, []byte(eicar)) {
+				reply("stream: Eicar-Test-Signature FOUND")
+			} else {
+				reply("stream: OK")
+			}
+		default:
+			reply("UNKNOWN COMMAND")
+			return
+		}
+
+		if !session {
+			return
+		}
+	}
+}
+
+func readChunks(r io.Reader, maxLength int) ([]byte, error) {
+	var data bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, err
+		}
+
+		if size == 0 {
+			return data.Bytes(), nil
+		}
+
+		if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+			return nil, err
+		}
+
+		if maxLength > 0 && data.Len() > maxLength {
+			return nil, errStreamMaxLength
+		}
+	}
+}
+
+//memQuarantine serves files from memory
+type memQuarantine map[string][]byte
+
+func (m memQuarantine) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	data, ok := m[filename]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m memQuarantine) Quarantine(ctx context.Context, location string) (Quarantine, error) {
+	return m, nil
+}
+
+func TestClamdScan(t *testing.T) {
+	fake := newFakeClamd(t, "tcp", "127.0.0.1:0")
+	defer fake.close()
+
+	clamd, err := NewClamd(fake.address(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clamd.Close()
+
+	large := bytes.Repeat([]byte("A"), 3*chunkSize+17)
+	tests := []struct {
+		input []byte
+		reply string
+	}{
+		{[]byte(eicar), "stream: Eicar-Test-Signature FOUND"},
+		{[]byte("clean"), "stream: OK"},
+		{large, "stream: OK"},
+		{[]byte{}, "stream: OK"},
+	}
+
+	for _, test := range tests {
+		reply, err := clamd.Scan(context.Background(), bytes.NewReader(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(reply) != test.reply {
+			t.Fatalf("Expected %q, Received %q", test.reply, reply)
+		}
+	}
+
+	if fake.connections() != 1 {
+		t.Fatalf("Expected session to be reused, %d connections made", fake.connections())
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if !bytes.Equal(fake.streamed[2], large) {
+		t.Fatalf("Expected %d bytes streamed, clamd received %d", len(large), len(fake.streamed[2]))
+	}
+}
+
+func TestClamdStreamMaxLength(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, address := range []string{"tcp://127.0.0.1:0", "unix://" + filepath.Join(dir, "clamd.sock")} {
+		network, addr, err := ParseAddress(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fake := newFakeClamd(t, network, addr)
+		fake.mu.Lock()
+		fake.maxLength = chunkSize
+		fake.mu.Unlock()
+
+		clamd, err := NewClamd(fake.address(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//far more than the socket buffers hold, so writes fail
+		large := bytes.Repeat([]byte("A"), 64<<20)
+		reply, err := clamd.Scan(context.Background(), bytes.NewReader(large))
+		clamd.Close()
+		fake.close()
+		if err != nil {
+			t.Fatalf("%s: Expected clamd's reply, Received %v", network, err)
+		}
+
+		if string(reply) != "INSTREAM size limit exceeded. ERROR" {
+			t.Fatalf("%s: Expected the size limit reply, Received %q", network, reply)
+		}
+
+		if status := NewParser().Parse(reply).Details.(plugins.VirusScanResult).Context.(Report).Status; status != StatusLimitsExceeded {
+			t.Fatalf("%s: Expected %s, Received %s", network, StatusLimitsExceeded, status)
+		}
+	}
+}
+
+func TestClamdUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "clamd.sock")
+	fake := newFakeClamd(t, "unix", socket)
+	defer fake.close()
+
+	clamd, err := NewClamd(socket, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clamd.Close()
+
+	if err := clamd.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClamdStaleSession(t *testing.T) {
+	fake := newFakeClamd(t, "tcp", "127.0.0.1:0")
+	defer fake.close()
+
+	clamd, err := NewClamd(fake.address(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clamd.Close()
+
+	if err := clamd.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	//simulate clamd dropping an idle session
+	s := <-clamd.idle
+	s.conn.Close()
+	clamd.idle <- s
+
+	if _, err := clamd.Scan(context.Background(), strings.NewReader("clean")); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.connections() != 2 {
+		t.Fatalf("Expected a new session, %d connections made", fake.connections())
+	}
+}
+
+func TestClamdCancelled(t *testing.T) {
+	//a clamd that accepts connections but never replies
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	clamd, err := NewClamd("tcp://"+l.Addr().String(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clamd.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		_, err := clamd.Scan(ctx, strings.NewReader("clean"))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("Expected %v, Received %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Scan did not return after cancellation")
+	}
+}
+
+func TestScannerClamd(t *testing.T) {
+	fake := newFakeClamd(t, "tcp", "127.0.0.1:0")
+	defer fake.close()
+
+	clamd, err := NewClamd(fake.address(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clamd.Close()
+
+	quarantine := memQuarantine{
+		"infected": []byte(eicar),
+		"clean":    []byte("clean"),
+	}
+	scanner := NewScanner(ScannerConfig{Engine: clamd, ScanTimeout: time.Minute, Quarantines: quarantine})
+
+	tests := []struct {
+		filename  string
+		positives int
+	}{
+		{"infected", 1},
+		{"clean", 0},
+	}
+
+	for _, test := range tests {
+		res, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: test.filename})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Type != plugins.VirusScan {
+			t.Fatalf("Expected %s, Received %s", plugins.VirusScan, res.Type)
+		}
+
+		details := res.Details.(plugins.VirusScanResult)
+		if details.Positives != test.positives {
+			t.Fatalf("Expected %d positives, Received %d", test.positives, details.Positives)
+		}
+	}
+
+	if _, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "missing"}); err == nil {
+		t.Fatal("Expected error for missing file")
+	}
+}
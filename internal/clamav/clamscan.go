@@ -0,0 +1,158 @@
+package clamav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opencensus.io/trace"
+
+	"github.com/worlvlhole/clamav-plugin/internal/logging"
+)
+
+//clamscan scans its stdin when given this path
+const stdinPath = "-"
+
+//Clamscan runs the clamscan executable against a
+//copy of the content in the local quarantine zone,
+//or against its stdin when streaming
+type Clamscan struct {
+	Executable          string   //path to clamscan
+	ProgramArgs         []string //args for clamscan
+	LocalQuarantineZone string   //location to store file contents
+	MinFreeBytes        int64    //free space the zone keeps beyond a copy
+	Stream              bool     //pipe content to clamscan's stdin
+}
+
+//NewClamscan creates a clamscan engine from the provided params
+func NewClamscan(programName, programPath string, programArgs []string,
+	localQuarantineZone string,
+	minFreeBytes int64,
+) *Clamscan {
+	return &Clamscan{
+		Executable:          path.Join(programPath, programName),
+		ProgramArgs:         programArgs,
+		LocalQuarantineZone: localQuarantineZone,
+		MinFreeBytes:        minFreeBytes,
+	}
+}
+
+//NewClamscanStream creates a clamscan engine that pipes
+//content to "clamscan -" without writing it to local disk
+func NewClamscanStream(programName, programPath string, programArgs []string) *Clamscan {
+	return &Clamscan{
+		Executable:  path.Join(programPath, programName),
+		ProgramArgs: programArgs,
+		Stream:      true,
+	}
+}
+
+//Scan copies r to a temporary file and runs clamscan on it.
+//Streaming engines hand r to clamscan's stdin instead.
+//Copies fail with ErrDiskSpace rather than filling the zone, up
+//front when the zone has no room for the most bytes the scan
+//may hand over plus MinFreeBytes
+func (c Clamscan) Scan(ctx context.Context, r io.Reader) ([]byte, error) {
+	logger := logging.FromContext(ctx).WithFields(log.Fields{"func": "Scan"})
+
+	if c.Stream {
+		cmd := exec.Command(c.Executable, c.args(stdinPath)...)
+		cmd.Stdin = r
+		return runGroup(ctx, cmd)
+	}
+
+	if need := copySize(ctx) + c.MinFreeBytes; need > 0 {
+		free, err := freeBytes(c.LocalQuarantineZone)
+		if err != nil {
+			return nil, err
+		}
+		if free < need {
+			return nil, fmt.Errorf("%w: %d bytes free, need %d", ErrDiskSpace, free, need)
+		}
+	}
+
+	file, err := ioutil.TempFile(c.LocalQuarantineZone, "clamav")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logger.Error(err)
+		}
+
+		if err := os.Remove(file.Name()); err != nil {
+			logger.Error(err)
+		}
+	}()
+
+	copied := time.Now()
+	_, span := trace.StartSpan(ctx, spanName(StageCopy))
+	_, err = io.Copy(file, r)
+	endSpan(span, err)
+	recordCopy(ctx, time.Since(copied))
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			err = ErrDiskSpace
+		}
+		return nil, err
+	}
+
+	return runGroup(ctx, exec.Command(c.Executable, c.args(file.Name())...))
+}
+
+//args returns the program args followed by the target.
+//They are copied so concurrent scans never share a backing array
+func (c Clamscan) args(target string) []string {
+	args := make([]string, 0, len(c.ProgramArgs)+1)
+	args = append(args, c.ProgramArgs...)
+	return append(args, target)
+}
+
+//freeBytes returns the space available to
+//unprivileged users on the filesystem holding dir
+func freeBytes(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+//runGroup runs cmd in its own process group and returns its
+//combined output. When ctx is done the whole group is
+//killed so nothing clamscan started outlives the scan
+func runGroup(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+				logging.FromContext(ctx).WithFields(log.Fields{"func": "runGroup"}).Error(err)
+			}
+		case <-done:
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+
+	return output.Bytes(), err
+}
@@ -0,0 +1,231 @@
+package clamav
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	//ModeClamscan shells out to clamscan for every file
+	ModeClamscan string = "clamscan"
+	//ModeClamd streams files to a running clamd
+	ModeClamd string = "clamd"
+
+	defaultClamdIdleConnections  = 4
+	defaultDatabaseCheckInterval = time.Hour
+	defaultMaxQueueDepth         = 16
+	defaultCacheSize             = 4096
+	defaultSelfTestInterval      = 5 * time.Minute
+	//matches the names clamav has given the EICAR signature
+	defaultSelfTestSignature = "eicar"
+	//clamav's own default MaxFileSize, it skips anything larger
+	defaultMaxStreamBytes      = 100 << 20
+	defaultMaxCompressionRatio = 100
+	defaultMinFreeBytes        = 64 << 20
+)
+
+//Configuration defines the items needed to select
+//and construct the clamav scanning backend
+type Configuration struct {
+	Mode                  string
+	ClamdAddress          string
+	ClamdIdleConnections  int
+	DatabaseDir           string
+	DatabaseMaxAge        time.Duration
+	DatabaseStrict        bool
+	DatabaseCheckInterval time.Duration
+	Stream                bool
+	MaxStreamBytes        int64
+	MaxCompressionRatio   int64
+	MinFreeBytes          int64
+	MaxParallelScans      int
+	MaxQueueDepth         int
+	CacheSize             int
+	CacheTTL              time.Duration
+	HashDatabases         []string
+	HashAllowlists        []string
+	HashDenylists         []string
+	AllowedLocations      []string
+	MetricsAddress        string
+	SelfTestInterval      time.Duration
+	SelfTestSignature     string
+}
+
+//NewConfigurationFromViper creates a Configuration from the values
+//provided by the viper instance. Limits and the cache size left
+//out of the config get their defaults
+func NewConfigurationFromViper(cfg *viper.Viper) Configuration {
+	return NewConfiguration(Configuration{
+		Mode:                  cfg.GetString("avscan.mode"),
+		ClamdAddress:          cfg.GetString("clamd.address"),
+		ClamdIdleConnections:  cfg.GetInt("clamd.idle_connections"),
+		DatabaseDir:           cfg.GetString("clamav.database_dir"),
+		DatabaseMaxAge:        cfg.GetDuration("clamav.database_max_age"),
+		DatabaseStrict:        cfg.GetBool("clamav.database_strict"),
+		DatabaseCheckInterval: cfg.GetDuration("clamav.database_check_interval"),
+		Stream:                cfg.GetBool("clamav.stream"),
+		MaxStreamBytes:        getInt64(cfg, "clamav.max_stream_bytes", defaultMaxStreamBytes),
+		MaxCompressionRatio:   getInt64(cfg, "clamav.max_compression_ratio", defaultMaxCompressionRatio),
+		MinFreeBytes:          getInt64(cfg, "clamav.min_free_bytes", defaultMinFreeBytes),
+		MaxParallelScans:      cfg.GetInt("clamav.max_parallel_scans"),
+		MaxQueueDepth:         cfg.GetInt("clamav.max_queue_depth"),
+		CacheSize:             getInt(cfg, "clamav.cache_size", defaultCacheSize),
+		CacheTTL:              cfg.GetDuration("clamav.cache_ttl"),
+		HashDatabases:         cfg.GetStringSlice("clamav.hash_databases"),
+		HashAllowlists:        cfg.GetStringSlice("clamav.hash_allowlists"),
+		HashDenylists:         cfg.GetStringSlice("clamav.hash_denylists"),
+		AllowedLocations:      cfg.GetStringSlice("clamav.allowed_locations"),
+		MetricsAddress:        cfg.GetString("metrics.address"),
+		SelfTestInterval:      cfg.GetDuration("health.self_test_interval"),
+		SelfTestSignature:     cfg.GetString("health.self_test_signature"),
+	})
+}
+
+//getInt returns the int at key, or def when key is not set
+//at all, so that an explicit 0 can turn a feature off
+func getInt(cfg *viper.Viper, key string, def int) int {
+	if !cfg.IsSet(key) {
+		return def
+	}
+	return cfg.GetInt(key)
+}
+
+//getInt64 is getInt for int64 values
+func getInt64(cfg *viper.Viper, key string, def int64) int64 {
+	if !cfg.IsSet(key) {
+		return def
+	}
+	return cfg.GetInt64(key)
+}
+
+//NewConfiguration creates a new Configuration from the provided values,
+//filling in the defaults of those left unset.
+//Zero max stream bytes and compression ratio are unlimited,
+//zero min free bytes skips the free space check and a zero
+//cache size turns the cache off
+func NewConfiguration(c Configuration) Configuration {
+	if c.Mode == "" {
+		c.Mode = ModeClamscan
+	}
+	c.Mode = strings.ToLower(c.Mode)
+
+	if c.ClamdIdleConnections == 0 {
+		c.ClamdIdleConnections = defaultClamdIdleConnections
+	}
+
+	if c.DatabaseCheckInterval == 0 {
+		c.DatabaseCheckInterval = defaultDatabaseCheckInterval
+	}
+
+	if c.MaxParallelScans == 0 {
+		c.MaxParallelScans = runtime.NumCPU()
+	}
+
+	if c.MaxQueueDepth == 0 {
+		c.MaxQueueDepth = defaultMaxQueueDepth
+	}
+
+	if c.SelfTestInterval == 0 {
+		c.SelfTestInterval = defaultSelfTestInterval
+	}
+
+	if c.SelfTestSignature == "" {
+		c.SelfTestSignature = defaultSelfTestSignature
+	}
+
+	return c
+}
+
+//Validate implements the Validate interface.
+func (c *Configuration) Validate() error {
+	if c.DatabaseMaxAge < 0 {
+		return errors.New("database max age is negative")
+	}
+
+	if c.DatabaseMaxAge > 0 && c.DatabaseDir == "" {
+		return errors.New("database max age requires a database dir")
+	}
+
+	if c.DatabaseCheckInterval < 0 {
+		return errors.New("database check interval is negative")
+	}
+
+	if c.MaxStreamBytes < 0 {
+		return errors.New("max stream bytes is negative")
+	}
+
+	if c.MaxCompressionRatio < 0 {
+		return errors.New("max compression ratio is negative")
+	}
+
+	if c.MinFreeBytes < 0 {
+		return errors.New("min free bytes is negative")
+	}
+
+	if c.MaxParallelScans < 0 {
+		return errors.New("max parallel scans is negative")
+	}
+
+	if c.MaxQueueDepth < 0 {
+		return errors.New("max queue depth is negative")
+	}
+
+	if c.CacheSize < 0 {
+		return errors.New("cache size is negative")
+	}
+
+	if c.CacheTTL < 0 {
+		return errors.New("cache ttl is negative")
+	}
+
+	if c.SelfTestInterval < 0 {
+		return errors.New("self-test interval is negative")
+	}
+
+	switch c.Mode {
+	case ModeClamscan:
+		return nil
+	case ModeClamd:
+	default:
+		return fmt.Errorf("invalid avscan mode %q", c.Mode)
+	}
+
+	if c.ClamdAddress == "" {
+		return errors.New("clamd address is empty")
+	}
+
+	if c.ClamdIdleConnections < 0 {
+		return errors.New("clamd idle connections is negative")
+	}
+
+	_, _, err := ParseAddress(c.ClamdAddress)
+	return err
+}
+
+//ParseAddress splits a clamd address into the network and
+//address understood by net.Dial. Addresses may be given as
+//tcp://host:port, unix:///path/to/clamd.sock, host:port
+//or an absolute socket path
+func ParseAddress(address string) (network string, addr string, err error) {
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		network, addr = "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "unix://"):
+		network, addr = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "/"):
+		network, addr = "unix", address
+	default:
+		network, addr = "tcp", address
+	}
+
+	if addr == "" {
+		return "", "", fmt.Errorf("invalid clamd address %q", address)
+	}
+
+	return network, addr, nil
+}
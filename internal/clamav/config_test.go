@@ -0,0 +1,50 @@
+package clamav
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestConfigurationLimits(t *testing.T) {
+	v := viper.New()
+	cfg := NewConfigurationFromViper(v)
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.MaxStreamBytes != defaultMaxStreamBytes ||
+		cfg.MaxCompressionRatio != defaultMaxCompressionRatio ||
+		cfg.MinFreeBytes != defaultMinFreeBytes {
+		t.Fatalf("Expected default limits, Received %d bytes, ratio %d, %d bytes free",
+			cfg.MaxStreamBytes, cfg.MaxCompressionRatio, cfg.MinFreeBytes)
+	}
+
+	//an explicit zero lifts the limit
+	v.Set("clamav.max_stream_bytes", 0)
+	v.Set("clamav.max_compression_ratio", 0)
+	v.Set("clamav.min_free_bytes", 0)
+	cfg = NewConfigurationFromViper(v)
+	if cfg.MaxStreamBytes != 0 || cfg.MaxCompressionRatio != 0 || cfg.MinFreeBytes != 0 {
+		t.Fatalf("Expected unlimited scans, Received %d bytes, ratio %d, %d bytes free",
+			cfg.MaxStreamBytes, cfg.MaxCompressionRatio, cfg.MinFreeBytes)
+	}
+}
+
+func TestConfigurationCacheSize(t *testing.T) {
+	v := viper.New()
+	if cfg := NewConfigurationFromViper(v); cfg.CacheSize != defaultCacheSize {
+		t.Fatalf("Expected %d entries by default, Received %d", defaultCacheSize, cfg.CacheSize)
+	}
+
+	//an explicit zero turns the cache off
+	v.Set("clamav.cache_size", 0)
+	cfg := NewConfigurationFromViper(v)
+	if cfg.CacheSize != 0 {
+		t.Fatalf("Expected the cache off, Received %d entries", cfg.CacheSize)
+	}
+
+	if cache := NewCache(cfg.CacheSize, 0); cache != nil {
+		t.Fatal("Expected no cache")
+	}
+}
@@ -0,0 +1,119 @@
+package clamav
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/worlvlhole/clamav-plugin/internal/logging"
+)
+
+//openFile opens filename in the quarantine, giving up when
+//ctx is done. The quarantine backends do not take a context,
+//so a reader that arrives after ctx is done is closed
+func openFile(ctx context.Context, quarantine Quarantine, filename string) (io.ReadCloser, error) {
+	type opened struct {
+		reader io.ReadCloser
+		err    error
+	}
+
+	result := make(chan opened, 1)
+	go func() {
+		reader, err := quarantine.OpenFile(ctx, filename)
+		result <- opened{reader, err}
+	}()
+
+	select {
+	case res := <-result:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return newContextReader(ctx, res.reader), nil
+	case <-ctx.Done():
+		go func() {
+			if res := <-result; res.reader != nil {
+				if err := res.reader.Close(); err != nil {
+					logging.FromContext(ctx).WithFields(log.Fields{"func": "openFile"}).Error(err)
+				}
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+//contextReader returns from Read as soon as ctx is done.
+//Reads of the underlying reader run in the background, so one
+//blocked on a stalled download is left behind instead of
+//holding up the scan. The underlying reader is only closed
+//once no Read of it is running, as decompressors and rclone
+//objects may not be closed during a Read. Quarantines close
+//the object they download from when the reader is closed,
+//which ends the download
+type contextReader struct {
+	ctx     context.Context
+	r       io.ReadCloser
+	buf     []byte
+	pending chan readResult //result of a Read left behind, nil when none
+	once    sync.Once
+}
+
+//readResult is the outcome of one background Read
+type readResult struct {
+	n   int
+	err error
+}
+
+func newContextReader(ctx context.Context, r io.ReadCloser) *contextReader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	//p is the caller's again once Read returns, so a
+	//Read that may be left behind fills a buffer of its own
+	if cap(c.buf) < len(p) {
+		c.buf = make([]byte, len(p))
+	}
+	buf := c.buf[:len(p)]
+
+	result := make(chan readResult, 1)
+	go func() {
+		n, err := c.r.Read(buf)
+		result <- readResult{n, err}
+	}()
+
+	select {
+	case res := <-result:
+		copy(p, buf[:res.n])
+		return res.n, res.err
+	case <-c.ctx.Done():
+		c.pending = result
+		return 0, c.ctx.Err()
+	}
+}
+
+//Close closes the underlying reader once. A Read left behind
+//when ctx was done is waited for in the background, as it
+//can take until the remote's timeout to return
+func (c *contextReader) Close() error {
+	var err error
+	c.once.Do(func() {
+		if c.pending == nil {
+			err = c.r.Close()
+			return
+		}
+
+		go func() {
+			<-c.pending
+			if err := c.r.Close(); err != nil {
+				logging.FromContext(c.ctx).WithFields(log.Fields{"func": "Close"}).Error(err)
+			}
+		}()
+	})
+	return err
+}
@@ -0,0 +1,61 @@
+package clamav
+
+import (
+	"context"
+	"sync"
+
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+)
+
+//flight runs one scan per key at a time. Requests for a key
+//that is already being scanned wait for and share its result
+type flight struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done chan struct{}
+	res  plugins.Result
+	err  error
+}
+
+func newFlight() *flight {
+	return &flight{calls: map[string]*call{}}
+}
+
+//Do runs fn unless a call for key is in progress, in which
+//case it waits for that call or fails with ErrCancelled once
+//ctx is done. shared reports whether the result came from
+//another caller's fn
+func (f *flight) Do(ctx context.Context, key string, fn func() (plugins.Result, error)) (res plugins.Result, err error, shared bool) {
+	if key == "" {
+		res, err = fn()
+		return res, err, false
+	}
+
+	f.mu.Lock()
+	if c, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.res, c.err, true
+		case <-ctx.Done():
+			return plugins.Result{}, ErrCancelled, false
+		}
+	}
+
+	c := &call{done: make(chan struct{})}
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.res, c.err = fn()
+	return c.res, c.err, false
+}
@@ -0,0 +1,68 @@
+package clamav
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+)
+
+//go test ./internal/clamav -run TestGolden -update
+var update = flag.Bool("update", false, "update golden files")
+
+//TestGolden parses every clamscan, clamdscan and clamd sample in
+//testdata and compares the result with its golden JSON. Samples in
+//testdata/synthetic were written by hand, not captured, and are
+//listed in testdata/SOURCES until capture.sh replaces them
+func TestGolden(t *testing.T) {
+	var samples []string
+	for _, dir := range []string{"testdata", filepath.Join("testdata", "synthetic")} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, matches...)
+	}
+
+	if len(samples) == 0 {
+		t.Fatal("no samples found in testdata")
+	}
+
+	for _, sample := range samples {
+		output, err := ioutil.ReadFile(sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//the result time changes on every parse
+		details := NewParser().Parse(output).Details.(plugins.VirusScanResult)
+		parsed, err := json.MarshalIndent(details, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, '\n')
+
+		golden := strings.TrimSuffix(sample, ".out") + ".golden.json"
+		if *update {
+			if err := ioutil.WriteFile(golden, parsed, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%s: %v, run with -update to create it", sample, err)
+		}
+
+		if !bytes.Equal(parsed, expected) {
+			t.Errorf("%s: parsed result does not match %s\nExpected:\n%s\nParsed:\n%s",
+				sample, golden, expected, parsed)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package clamav
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/worlvlhole/maladapt/pkg/digests"
+
+	"github.com/worlvlhole/clamav-plugin/internal/logging"
+)
+
+//ErrIntegrity the quarantined content does not
+//match the digests in the scan request
+var ErrIntegrity = errors.New("content does not match digest")
+
+//IntegrityError is returned when the quarantined content
+//hashes to a different digest than the one requested.
+//It matches ErrIntegrity
+type IntegrityError struct {
+	Algorithm digests.Algorithm
+	Expected  string //hex digest from the request
+	Actual    string //hex digest of the quarantined content
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("%s: %s expected %s, received %s", ErrIntegrity, e.Algorithm, e.Expected, e.Actual)
+}
+
+//Is reports whether target is ErrIntegrity
+func (e *IntegrityError) Is(target error) bool {
+	return target == ErrIntegrity
+}
+
+//the digests package only hashes whole buffers,
+//these hash the same algorithms while streaming
+var digestHashes = map[digests.Algorithm]func() hash.Hash{
+	digests.MD5:       md5.New,
+	digests.SHA1:      sha1.New,
+	digests.SHA224:    sha256.New224,
+	digests.SHA256:    sha256.New,
+	digests.SHA384:    sha512.New384,
+	digests.SHA512:    sha512.New,
+	digests.SHA512224: sha512.New512_224,
+	//maladapt computes its sha512/256 digests with Sum512_224
+	digests.SHA512256: sha512.New512_224,
+}
+
+//digestReader hashes the content read through it with
+//the algorithms of the expected digests
+type digestReader struct {
+	r        io.Reader
+	w        io.Writer
+	expected []digests.Digest
+	hashes   []hash.Hash
+}
+
+//newDigestReader creates a digestReader verifying the expected
+//digests. Digests of unsupported algorithms are ignored
+func newDigestReader(ctx context.Context, r io.Reader, expected []digests.Digest) *digestReader {
+	d := &digestReader{r: r}
+
+	writers := make([]io.Writer, 0, len(expected))
+	for _, digest := range expected {
+		newHash, ok := digestHashes[strings.ToLower(digest.Algorithm)]
+		if !ok || len(digest.Hash) == 0 {
+			logging.FromContext(ctx).WithFields(log.Fields{"func": "newDigestReader"}).
+				Debug("not verifying digest algorithm ", digest.Algorithm)
+			continue
+		}
+
+		h := newHash()
+		d.expected = append(d.expected, digest)
+		d.hashes = append(d.hashes, h)
+		writers = append(writers, h)
+	}
+	d.w = io.MultiWriter(writers...)
+
+	return d
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.w.Write(p[:n])
+	return n, err
+}
+
+//Verify compares the digests of the content read so
+//far with the expected digests
+func (d *digestReader) Verify() error {
+	for i, h := range d.hashes {
+		if actual := h.Sum(nil); !bytes.Equal(actual, d.expected[i].Hash) {
+			return &IntegrityError{
+				Algorithm: d.expected[i].Algorithm,
+				Expected:  hex.EncodeToString(d.expected[i].Hash),
+				Actual:    hex.EncodeToString(actual),
+			}
+		}
+	}
+	return nil
+}
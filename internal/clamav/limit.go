@@ -0,0 +1,112 @@
+package clamav
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+//ErrStreamLimit the quarantined content is larger than
+//the maximum number of bytes handed to the engine
+var ErrStreamLimit = errors.New("content exceeds max stream bytes")
+
+//ErrCompressionRatio the quarantined content decompresses to
+//more than the maximum ratio of its stored size
+var ErrCompressionRatio = errors.New("content exceeds max compression ratio")
+
+//ErrDiskSpace the local quarantine zone has too little
+//free space left to hold the content
+var ErrDiskSpace = errors.New("local quarantine zone is out of space")
+
+//minRatioBytes is the least the compression ratio limit allows.
+//Small files routinely compress far better than large ones
+const minRatioBytes = 1 << 20
+
+type copySizeKey struct{}
+
+//withCopySize tells the engine the most bytes it will be handed,
+//so it can check a copy fits before starting one. A size that
+//is not positive is unlimited and left out
+func withCopySize(ctx context.Context, n int64) context.Context {
+	if n <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, copySizeKey{}, n)
+}
+
+//copySize returns the most bytes the engine will be handed,
+//or 0 when that is unlimited
+func copySize(ctx context.Context) int64 {
+	n, _ := ctx.Value(copySizeKey{}).(int64)
+	return n
+}
+
+//limitReader reads at most n bytes from r. Unlike
+//io.LimitReader it fails with err rather than
+//silently truncating content that is larger than n
+type limitReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+	exceeded  bool
+}
+
+//newLimitReader returns r unchanged when n is not positive
+func newLimitReader(r io.Reader, n int64) io.Reader {
+	return newLimitReaderErr(r, n, ErrStreamLimit)
+}
+
+//newLimitReaderErr is newLimitReader failing with err
+func newLimitReaderErr(r io.Reader, n int64, err error) io.Reader {
+	if n <= 0 {
+		return r
+	}
+	return &limitReader{r: r, remaining: n, err: err}
+}
+
+//decompressionLimit returns the most bytes a file stored in
+//storedSize bytes may decompress to, and the error to fail with
+//beyond it. maxBytes and maxRatio of 0 are unlimited, as is
+//the ratio when the stored size is unknown
+func decompressionLimit(maxBytes int64, maxRatio int64, storedSize int64) (int64, error) {
+	if maxRatio <= 0 || storedSize < 0 {
+		return maxBytes, ErrStreamLimit
+	}
+
+	limit := storedSize * maxRatio
+	if limit/maxRatio != storedSize {
+		//overflowed, so the ratio can never be reached
+		return maxBytes, ErrStreamLimit
+	}
+	if limit < minRatioBytes {
+		limit = minRatioBytes
+	}
+
+	if maxBytes > 0 && maxBytes <= limit {
+		return maxBytes, ErrStreamLimit
+	}
+	return limit, ErrCompressionRatio
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, l.err
+	}
+
+	//read one byte past the limit to tell a file of
+	//exactly n bytes apart from a larger one
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		l.exceeded = true
+		n = int(l.remaining)
+		l.remaining = 0
+		return n, l.err
+	}
+
+	l.remaining -= int64(n)
+	return n, err
+}
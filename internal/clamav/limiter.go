@@ -0,0 +1,88 @@
+package clamav
+
+import (
+	"context"
+	"time"
+)
+
+//ErrBusy every scan slot and queue position is taken.
+//The request may be retried once the scanner drains
+var ErrBusy error = temporaryError("scanner busy, retry later")
+
+//temporaryError is an error that implements Temporary
+type temporaryError string
+
+func (e temporaryError) Error() string {
+	return string(e)
+}
+
+//Temporary reports that the request may be retried
+func (e temporaryError) Temporary() bool {
+	return true
+}
+
+//Limiter bounds the number of scans running at once and
+//the number of scans waiting for a slot
+type Limiter struct {
+	slots   chan struct{} //held by running scans
+	pending chan struct{} //held by running and queued scans
+}
+
+//NewLimiter creates a Limiter running at most maxParallel
+//scans with at most maxQueue more waiting for a slot
+func NewLimiter(maxParallel, maxQueue int) *Limiter {
+	return &Limiter{
+		slots:   make(chan struct{}, maxParallel),
+		pending: make(chan struct{}, maxParallel+maxQueue),
+	}
+}
+
+//Acquire waits for a scan slot and returns the time spent
+//waiting along with the func releasing the slot. It fails
+//with ErrBusy when the queue is full, or ctx's error when
+//ctx is done first. A nil Limiter never waits
+func (l *Limiter) Acquire(ctx context.Context) (time.Duration, func(), error) {
+	if l == nil {
+		return 0, func() {}, nil
+	}
+
+	select {
+	case l.pending <- struct{}{}:
+	default:
+		return 0, nil, ErrBusy
+	}
+
+	start := time.Now()
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		<-l.pending
+		return time.Since(start), nil, ctx.Err()
+	}
+
+	return time.Since(start), func() {
+		<-l.slots
+		<-l.pending
+	}, nil
+}
+
+//Running returns the number of scans holding a slot
+func (l *Limiter) Running() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots)
+}
+
+//Queued returns the number of scans waiting for a slot
+func (l *Limiter) Queued() int {
+	if l == nil {
+		return 0
+	}
+
+	//pending is acquired first and released last
+	if queued := len(l.pending) - len(l.slots); queued > 0 {
+		return queued
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+package clamav
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/worlvlhole/maladapt/pkg/ipc"
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(1, 1)
+
+	_, release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the second scan queues until the first releases its slot
+	acquired := make(chan time.Duration)
+	go func() {
+		wait, release, err := limiter.Acquire(context.Background())
+		if err != nil {
+			t.Error(err)
+			close(acquired)
+			return
+		}
+		release()
+		acquired <- wait
+	}()
+
+	for limiter.Queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, _, err := limiter.Acquire(context.Background()); err != ErrBusy {
+		t.Fatalf("Expected %v, Received %v", ErrBusy, err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	release()
+
+	if wait := <-acquired; wait < 20*time.Millisecond {
+		t.Fatalf("Expected queued scan to wait at least 20ms, Received %s", wait)
+	}
+
+	if limiter.Running() != 0 || limiter.Queued() != 0 {
+		t.Fatalf("Expected empty limiter, Received %d running %d queued", limiter.Running(), limiter.Queued())
+	}
+}
+
+func TestLimiterCancelled(t *testing.T) {
+	limiter := NewLimiter(1, 1)
+	_, release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := limiter.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v, Received %v", context.DeadlineExceeded, err)
+	}
+
+	if limiter.Queued() != 0 {
+		t.Fatalf("Expected cancelled scan to leave the queue, %d queued", limiter.Queued())
+	}
+}
+
+func TestScannerQueueWait(t *testing.T) {
+	limiter := NewLimiter(1, 0)
+	quarantine := memQuarantine{"file": []byte("content")}
+	engine := fakeEngine{output: []byte("stream: OK")}
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine, Limiter: limiter})
+
+	_, release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "file"}); err != ErrBusy {
+		t.Fatalf("Expected %v, Received %v", ErrBusy, err)
+	}
+
+	limiter = NewLimiter(1, 1)
+	scanner = NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine, Limiter: limiter})
+	release()
+	if _, release, err = limiter.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(20*time.Millisecond, release)
+
+	res, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wait := res.Details.(plugins.VirusScanResult).Context.(Report).QueueWait; wait < 20*time.Millisecond {
+		t.Fatalf("Expected queue wait of at least 20ms, Received %s", wait)
+	}
+}
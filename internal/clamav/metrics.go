@@ -0,0 +1,178 @@
+package clamav
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+
+	"github.com/worlvlhole/clamav-plugin/internal/cvd"
+)
+
+//Stages of a scan timed by Metrics
+const (
+	StageFetch = "fetch" //opening the quarantined file
+	StageCopy  = "copy"  //copying content to the local quarantine zone
+	StageScan  = "scan"  //running clamscan or streaming to clamd
+	StageParse = "parse" //parsing the engine output
+)
+
+//StatusFailed counts scans that returned an error
+//rather than a Report
+const StatusFailed Status = "failed"
+
+//stageBuckets are latency buckets in seconds, from
+//a cache hit to a large archive on a slow remote
+var stageBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120}
+
+//Metrics records scan throughput, latency and verdicts.
+//All methods are safe to call on a nil Metrics
+type Metrics struct {
+	inFlight  int64 //first for 64 bit alignment of atomics
+	scans     *prometheus.CounterVec
+	positives prometheus.Counter
+	bytes     prometheus.Counter
+	stages    *prometheus.HistogramVec
+}
+
+//NewMetrics registers the scan metrics with registerer, along with
+//gauges of the limiter's queue and the signature database versions
+func NewMetrics(registerer prometheus.Registerer, limiter *Limiter, databases *cvd.Monitor) *Metrics {
+	m := &Metrics{
+		scans: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "clamav_scans_total",
+			Help: "Scan requests answered, by report status.",
+		}, []string{"status"}),
+		positives: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "clamav_positives_total",
+			Help: "Detections reported by scans.",
+		}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "clamav_scanned_bytes_total",
+			Help: "Decompressed bytes handed to the engine.",
+		}),
+		stages: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "clamav_scan_stage_duration_seconds",
+			Help:    "Time spent in each stage of a scan.",
+			Buckets: stageBuckets,
+		}, []string{"stage"}),
+	}
+
+	registerer.MustRegister(
+		m.scans,
+		m.positives,
+		m.bytes,
+		m.stages,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "clamav_scans_in_flight",
+			Help: "Scan requests being answered.",
+		}, func() float64 {
+			return float64(atomic.LoadInt64(&m.inFlight))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "clamav_scans_queued",
+			Help: "Scans waiting for a free engine slot.",
+		}, func() float64 {
+			return float64(limiter.Queued())
+		}),
+		databaseCollector{
+			databases: databases,
+			desc: prometheus.NewDesc("clamav_database_version",
+				"Version of each loaded signature database.", []string{"database"}, nil),
+		},
+	)
+
+	return m
+}
+
+//databaseCollector reads the version of each loaded
+//signature database at scrape time
+type databaseCollector struct {
+	databases *cvd.Monitor
+	desc      *prometheus.Desc
+}
+
+//Describe implements prometheus.Collector
+func (c databaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+//Collect implements prometheus.Collector
+func (c databaseCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, header := range c.databases.Headers() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(header.Version), header.Name)
+	}
+}
+
+//begin counts a scan in flight until the returned func
+//records how it was answered
+func (m *Metrics) begin() func(plugins.Result, error) {
+	if m == nil {
+		return func(plugins.Result, error) {}
+	}
+
+	atomic.AddInt64(&m.inFlight, 1)
+	return func(res plugins.Result, err error) {
+		atomic.AddInt64(&m.inFlight, -1)
+
+		report, ok := reportOf(res)
+		switch {
+		case err != nil || !ok:
+			m.scans.WithLabelValues(string(StatusFailed)).Inc()
+		default:
+			m.scans.WithLabelValues(string(report.Status)).Inc()
+			m.positives.Add(float64(len(report.Detections)))
+		}
+	}
+}
+
+//observe records the time spent in a stage
+func (m *Metrics) observe(stage string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.stages.WithLabelValues(stage).Observe(d.Seconds())
+}
+
+//scanned records the bytes handed to the engine
+func (m *Metrics) scanned(n int64) {
+	if m == nil {
+		return
+	}
+	m.bytes.Add(float64(n))
+}
+
+//stageTimes collects the stages an engine times itself
+type stageTimes struct {
+	copy time.Duration
+}
+
+type stageTimesKey struct{}
+
+func withStageTimes(ctx context.Context) (context.Context, *stageTimes) {
+	times := &stageTimes{}
+	return context.WithValue(ctx, stageTimesKey{}, times), times
+}
+
+//recordCopy notes how long an engine spent copying content
+//before scanning it, so it is not counted as scan time
+func recordCopy(ctx context.Context, d time.Duration) {
+	if times, ok := ctx.Value(stageTimesKey{}).(*stageTimes); ok {
+		times.copy += d
+	}
+}
+
+//countReader counts the bytes read from r
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
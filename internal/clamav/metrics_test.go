@@ -0,0 +1,124 @@
+package clamav
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/worlvlhole/maladapt/pkg/ipc"
+
+	"github.com/worlvlhole/clamav-plugin/internal/cvd"
+)
+
+func scrape(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestScannerMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//stand in for clamscan so the copy stage is timed
+	script := "#!/bin/sh\nif grep -q EICAR \"$2\"; then echo \"$2: Eicar-Test-Signature FOUND\"; exit 1; fi\necho \"$2: OK\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "clamscan"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	zone := filepath.Join(dir, "zone")
+	if err := os.Mkdir(zone, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeDaily(t, dir, 27000)
+
+	monitor := cvd.NewMonitor(dir, 0, false)
+	if err := monitor.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := prometheus.NewRegistry()
+	limiter := NewLimiter(1, 1)
+	quarantine := memQuarantine{"clean": []byte("content"), "eicar": []byte(eicar)}
+	engine := NewClamscan("clamscan", dir, []string{"--no-summary"}, zone, 0)
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine, Databases: monitor, Limiter: limiter, Metrics: NewMetrics(registry, limiter, monitor)})
+
+	server := httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	defer server.Close()
+
+	for _, filename := range []string{"clean", "clean", "eicar", "missing"} {
+		scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: filename})
+	}
+
+	body := scrape(t, server.URL)
+	for _, line := range []string{
+		`clamav_scans_total{status="clean"} 2`,
+		`clamav_scans_total{status="infected"} 1`,
+		`clamav_scans_total{status="failed"} 1`,
+		`clamav_positives_total 1`,
+		`clamav_scanned_bytes_total ` + strconv.Itoa(2*len("content")+len(eicar)),
+		`clamav_scan_stage_duration_seconds_count{stage="fetch"} 4`,
+		`clamav_scan_stage_duration_seconds_count{stage="copy"} 3`,
+		`clamav_scan_stage_duration_seconds_count{stage="scan"} 3`,
+		`clamav_scan_stage_duration_seconds_count{stage="parse"} 3`,
+		`clamav_scans_in_flight 0`,
+		`clamav_scans_queued 0`,
+		`clamav_database_version{database="daily"} 27000`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("Expected %s in\n%s", line, body)
+		}
+	}
+}
+
+//headEngine reads only the first n bytes, as clamd does
+//of a file beyond its StreamMaxLength
+type headEngine struct {
+	n int64
+}
+
+func (h headEngine) Scan(ctx context.Context, r io.Reader) ([]byte, error) {
+	if _, err := io.CopyN(ioutil.Discard, r, h.n); err != nil {
+		return nil, err
+	}
+	return []byte("stream: OK"), nil
+}
+
+func TestScannerMetricsScannedBytes(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	quarantine := memQuarantine{"file": []byte("content")}
+	scanner := NewScanner(ScannerConfig{Engine: headEngine{n: 3}, ScanTimeout: time.Minute, Quarantines: quarantine, Metrics: NewMetrics(registry, nil, nil)})
+
+	server := httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	defer server.Close()
+
+	if _, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "file"}); err != nil {
+		t.Fatal(err)
+	}
+
+	//the rest is read to verify the content, not handed to the engine
+	if body := scrape(t, server.URL); !strings.Contains(body, "clamav_scanned_bytes_total 3\n") {
+		t.Fatalf("Expected 3 scanned bytes in\n%s", body)
+	}
+}
@@ -11,48 +11,211 @@ import (
 type Parser struct{}
 
 const (
-	found string = "FOUND"
-	ok    string = "OK"
+	found    string = "FOUND"
+	ok       string = "OK"
+	errToken string = "ERROR"
+
+	//signature reported by --alert-exceeds-max
+	limitsExceededSignature string = "Heuristics.Limits.Exceeded"
 )
 
+//per file replies for objects clamscan did not scan
+var skippedReplies = []string{
+	"Empty file",
+	"Excluded",
+	"Symbolic link",
+}
+
+//fragments of warnings and errors reporting that
+//a file was only partially scanned
+var limitsExceededMessages = []string{
+	"size limit exceeded",
+	"exceeds limits",
+	"Limits Exceeded",
+}
+
 //NewParser creates a parser that knows
 //how to parse output from clamscan
 func NewParser() *Parser {
 	return &Parser{}
 }
 
-//Parse checks the provided data for clamscan output
+//Parse checks the provided data for clamscan output.
+//The result Context is a Report
 func (p Parser) Parse(output []byte) (res plugins.Result) {
 	res.Time = time.Now()
 	res.Type = plugins.VirusScan
 
-	scanResult := plugins.VirusScanResult{
-		Positives:  0,
-		TotalScans: 1,
+	report := NewReport()
+	var limited bool
+
+	//per file entries in the order clamav reported them
+	index := map[string]int{}
+	object := func(path string) *Object {
+		i, ok := index[path]
+		if !ok {
+			i = len(report.Objects)
+			index[path] = i
+			report.Objects = append(report.Objects, newObject(path))
+		}
+		return &report.Objects[i]
 	}
 
-	context := map[string]string{}
-	strOutput := string(output)
+	strOutput := strings.Replace(string(output), "\r\n", "\n", -1)
 	lines := strings.Split(strOutput, "\n")
 	for _, line := range lines {
-		pair := strings.Split(line, ":")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if containsAny(line, limitsExceededMessages) {
+			limited = true
+		}
 
-		if len(pair) == 2 {
-			if strings.Contains(pair[1], found) {
-				scanResult.Positives = scanResult.Positives + 1
-				context[found] = strings.TrimSpace(strings.TrimRight(pair[1], found))
-				continue
+		if path, reply, isFile := splitFileLine(line); isFile {
+			obj := object(path)
+			switch {
+			case strings.HasSuffix(reply, " "+found):
+				signature := strings.TrimSpace(strings.TrimSuffix(reply, found))
+				if strings.HasPrefix(signature, limitsExceededSignature) {
+					obj.setStatus(StatusLimitsExceeded)
+					continue
+				}
+
+				obj.setStatus(StatusInfected)
+				obj.Signatures = append(obj.Signatures, signature)
+				report.Detections = append(report.Detections, Detection{
+					Path:      path,
+					Signature: signature,
+				})
+			case reply == ok:
+				obj.setStatus(StatusClean)
+			default:
+				obj.setStatus(StatusSkipped)
 			}
+			continue
+		}
 
-			if strings.Contains(pair[1], ok) {
-				continue
+		if isErrorLine(line) {
+			if i := strings.Index(line, ": "); i > 0 && !strings.HasPrefix(line, "ERROR:") &&
+				!strings.HasPrefix(line, "LibClamAV Error:") {
+				object(line[:i]).setStatus(StatusError)
 			}
+			report.Errors = append(report.Errors, line)
+			continue
+		}
 
-			context[pair[0]] = strings.TrimSpace(pair[1])
+		if isWarningLine(line) {
+			report.Warnings = append(report.Warnings, line)
+			continue
 		}
+
+		if key, value, ok := splitSummary(line); ok {
+			report.summarize(key, value)
+			continue
+		}
+
+		if i := strings.Index(line, ": "); i > 0 {
+			report.summarize(strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+2:]))
+		}
+	}
+
+	//objects are clamav's verdicts, the summary covers
+	//files it examined without printing them (--infected)
+	var positives, examined int
+	status := StatusSkipped
+	for _, obj := range report.Objects {
+		if obj.Status == StatusInfected {
+			positives++
+		}
+		if obj.Status != StatusSkipped && obj.Status != StatusError {
+			examined++
+		}
+		status = worse(status, obj.Status)
+	}
+
+	if report.ScannedFiles > examined {
+		examined = report.ScannedFiles
+		status = worse(status, StatusClean)
+	}
+
+	if limited {
+		status = worse(status, StatusLimitsExceeded)
+	}
+
+	if len(report.Errors) > 0 {
+		status = worse(status, StatusError)
+	}
+
+	//an empty or unrecognized output is never clean
+	if len(report.Objects) == 0 && examined == 0 {
+		status = worse(status, StatusError)
+	}
+
+	report.Status = status
+
+	res.Details = plugins.VirusScanResult{
+		Positives:  positives,
+		TotalScans: examined,
+		Context:    report,
 	}
-	scanResult.Context = context
-	res.Details = scanResult
 
 	return res
 }
+
+//splitFileLine splits a per file line such as
+//"/q/file.zip/dir:x.exe: Win.Trojan.Agent FOUND" into the path
+//and clamav's reply. Paths may contain colons, signature names
+//and replies never contain ": " so the last one separates them
+func splitFileLine(line string) (path string, reply string, matched bool) {
+	i := strings.LastIndex(line, ": ")
+	if i <= 0 {
+		return "", "", false
+	}
+
+	path, reply = line[:i], line[i+2:]
+	switch {
+	case reply == ok:
+	case strings.HasSuffix(reply, " "+found) && len(reply) > len(found)+1:
+	case isSkippedReply(reply):
+	default:
+		return "", "", false
+	}
+
+	return path, reply, true
+}
+
+func isSkippedReply(reply string) bool {
+	for _, skipped := range skippedReplies {
+		if reply == skipped {
+			return true
+		}
+	}
+	return false
+}
+
+//isErrorLine reports whether the line is an error
+//printed by clamscan, libclamav or clamd
+func isErrorLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, "ERROR:") ||
+		strings.HasPrefix(line, "LibClamAV Error:") ||
+		strings.HasSuffix(line, " "+errToken)
+}
+
+//isWarningLine reports whether the line is a warning
+//printed by clamscan or libclamav
+func isWarningLine(line string) bool {
+	return strings.HasPrefix(line, "WARNING:") ||
+		strings.HasPrefix(line, "LibClamAV Warning:")
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,9 @@
 package clamav
 
 import (
+	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -31,16 +34,30 @@ Time: 15.779 sec (0 m 15 s)`,
 			Details: plugins.VirusScanResult{
 				Positives:  1,
 				TotalScans: 1,
-				Context: map[string]string{
-					"FOUND":               "Eicar-Test-Signature",
-					"Known viruses":       "6661373",
-					"Engine version":      "0.100.1",
-					"Scanned directories": "0",
-					"Scanned files":       "1",
-					"Infected files":      "1",
-					"Data scanned":        "0.00 MB",
-					"Data read":           "0.00 MB (ratio 0.00:1)",
-					"Time":                "15.779 sec (0 m 15 s)",
+				Context: Report{
+					Version: ReportVersion,
+					Status:  StatusInfected,
+					Detections: []Detection{
+						{"/quarantine_zone/f91fd0505c91af2156892429a0746b93dd3e9322784cc6c947a99ba4629662573", "Eicar-Test-Signature"},
+					},
+					Objects: []Object{
+						{
+							Path:       "/quarantine_zone/f91fd0505c91af2156892429a0746b93dd3e9322784cc6c947a99ba4629662573",
+							Status:     StatusInfected,
+							Signatures: []string{"Eicar-Test-Signature"},
+						},
+					},
+					Warnings: []string{
+						"LibClamAV Warning: Cannot dlopen libclamunrar_iface: file not found - unrar support unavailable",
+					},
+					KnownSignatures:    6661373,
+					EngineVersion:      "0.100.1",
+					ScannedDirectories: 0,
+					ScannedFiles:       1,
+					InfectedFiles:      1,
+					BytesScanned:       0,
+					BytesRead:          0,
+					Duration:           15779 * time.Millisecond,
 				},
 			},
 		},
@@ -61,15 +78,66 @@ Time: 15.779 sec (0 m 15 s)`,
 			Details: plugins.VirusScanResult{
 				Positives:  0,
 				TotalScans: 1,
-				Context: map[string]string{
-					"Known viruses":       "6661373",
-					"Engine version":      "0.100.1",
-					"Scanned directories": "0",
-					"Scanned files":       "1",
-					"Infected files":      "1",
-					"Data scanned":        "0.00 MB",
-					"Data read":           "100 MB (ratio 0.00:1)",
-					"Time":                "15.756 sec (0 m 15 s)",
+				Context: Report{
+					Version:    ReportVersion,
+					Status:     StatusClean,
+					Detections: []Detection{},
+					Objects: []Object{
+						{
+							Path:   "/quarantine_zone/2c0ca0f9922e478ba853d93b5826529bd05af33a062037702",
+							Status: StatusClean,
+						},
+					},
+					KnownSignatures:    6661373,
+					EngineVersion:      "0.100.1",
+					ScannedDirectories: 0,
+					ScannedFiles:       1,
+					InfectedFiles:      0,
+					BytesScanned:       0,
+					BytesRead:          100 << 20,
+					Duration:           15756 * time.Millisecond,
+				},
+			},
+		},
+	},
+	{`/quarantine_zone/2c0ca0f9922e478ba853d93b5826529bd05af33a062037702: OK
+
+----------- SCAN SUMMARY -----------
+Known viruses: 8674139
+Engine version: 1.0.1
+Scanned directories: 0
+Scanned files: 1
+Infected files: 0
+Data scanned: 0.00 MB
+Data read: 0.00 MB (ratio 0.00:1)
+Time: 9.112 sec (0 m 9 s)
+Start Date: 2023:03:29 10:16:38
+End Date:   2023:03:29 10:16:47`,
+		plugins.Result{
+			Time: time.Now(),
+			Type: plugins.VirusScan,
+			Details: plugins.VirusScanResult{
+				Positives:  0,
+				TotalScans: 1,
+				Context: Report{
+					Version:    ReportVersion,
+					Status:     StatusClean,
+					Detections: []Detection{},
+					Objects: []Object{
+						{
+							Path:   "/quarantine_zone/2c0ca0f9922e478ba853d93b5826529bd05af33a062037702",
+							Status: StatusClean,
+						},
+					},
+					KnownSignatures: 8674139,
+					EngineVersion:   "1.0.1",
+					ScannedFiles:    1,
+					Duration:        9112 * time.Millisecond,
+					//summary lines the parser does not know
+					Extra: map[string]string{
+						"Start Date": "2023:03:29 10:16:38",
+						"End Date":   "2023:03:29 10:16:47",
+					},
 				},
 			},
 		},
@@ -98,22 +166,222 @@ func TestParser(t *testing.T) {
 			t.Fatalf("Expected %d positives, Parsed %d", expectedResults.Positives, parserResults.Positives)
 		}
 
-		parserContext := parserResults.Context.(map[string]string)
-		expectedContext := parserResults.Context.(map[string]string)
+		parserReport := parserResults.Context.(Report)
+		expectedReport := expectedResults.Context.(Report)
 
-		if len(parserContext) != len(expectedContext) {
-			t.Fatalf("Expected %d context results, Parsed %d", len(expectedContext), len(parserContext))
+		if !reflect.DeepEqual(parserReport, expectedReport) {
+			t.Fatalf("Expected %+v, Parsed %+v", expectedReport, parserReport)
 		}
+	}
+}
 
-		for k, v := range expectedContext {
-			parsed, ok := parserContext[k]
-			if !ok {
-				t.Fatalf("%s not present in context", k)
-			}
+func TestParserDetections(t *testing.T) {
+	output := `/quarantine_zone/sample.zip: Win.Trojan.Agent-1 FOUND
+/quarantine_zone/sample.zip: Eicar-Test-Signature FOUND
+/quarantine_zone/other.exe: Win.Worm.FOUNDER FOUND
+/quarantine_zone/clean.txt: OK`
+
+	expected := []Detection{
+		{"/quarantine_zone/sample.zip", "Win.Trojan.Agent-1"},
+		{"/quarantine_zone/sample.zip", "Eicar-Test-Signature"},
+		{"/quarantine_zone/other.exe", "Win.Worm.FOUNDER"},
+	}
+
+	result := NewParser().Parse([]byte(output))
+	details := result.Details.(plugins.VirusScanResult)
 
-			if v != parsed {
-				t.Fatalf("Expected %s, Parsed %s", k, parsed)
+	//positives count infected files, not signatures
+	if details.Positives != 2 {
+		t.Fatalf("Expected %d positives, Parsed %d", 2, details.Positives)
+	}
+
+	if details.TotalScans != 3 {
+		t.Fatalf("Expected %d scans, Parsed %d", 3, details.TotalScans)
+	}
+
+	detections := details.Context.(Report).Detections
+	if !reflect.DeepEqual(detections, expected) {
+		t.Fatalf("Expected %v, Parsed %v", expected, detections)
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	report := NewParser().Parse([]byte(TestTable[0].input)).Details.(plugins.VirusScanResult).Context
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"version":          float64(ReportVersion),
+		"status":           "infected",
+		"known_signatures": float64(6661373),
+		"engine_version":   "0.100.1",
+		"duration":         float64(15779 * time.Millisecond),
+	}
+
+	for k, v := range expected {
+		if decoded[k] != v {
+			t.Fatalf("Expected %s to be %v, Serialized %v", k, v, decoded[k])
+		}
+	}
+
+	warnings := decoded["warnings"].([]interface{})
+	if len(warnings) != 1 {
+		t.Fatalf("Expected warnings, Serialized %v", decoded["warnings"])
+	}
+}
+
+func TestParserColonsAndCRLF(t *testing.T) {
+	output := "LibClamAV Warning: Cannot dlopen libclamunrar_iface: file not found\r\n" +
+		"/quarantine_zone/file.zip/dir:x.exe: Win.Trojan.Agent-1 FOUND\r\n" +
+		"C:\\quarantine\\sample.exe: OK\r\n" +
+		"\r\n" +
+		"----------- SCAN SUMMARY -----------\r\n" +
+		"Scanned files: 2\r\n" +
+		"Infected files: 1\r\n" +
+		"Data read: 1.50 MB (ratio 0.00:1)\r\n" +
+		"Time: 15.779 sec (0 m 15 s)\r\n" +
+		"Start Date: 2023:03:29 10:16:47\r\n"
+
+	report := NewParser().Parse([]byte(output)).Details.(plugins.VirusScanResult).Context.(Report)
+
+	expected := []Detection{{"/quarantine_zone/file.zip/dir:x.exe", "Win.Trojan.Agent-1"}}
+	if !reflect.DeepEqual(report.Detections, expected) {
+		t.Fatalf("Expected %v, Parsed %v", expected, report.Detections)
+	}
+
+	if report.ScannedFiles != 2 || report.InfectedFiles != 1 {
+		t.Fatalf("Expected 2 scanned and 1 infected, Parsed %d and %d", report.ScannedFiles, report.InfectedFiles)
+	}
+
+	if report.BytesRead != 3<<19 {
+		t.Fatalf("Expected %d bytes read, Parsed %d", 3<<19, report.BytesRead)
+	}
+
+	if report.Duration != 15779*time.Millisecond {
+		t.Fatalf("Expected %s, Parsed %s", 15779*time.Millisecond, report.Duration)
+	}
+
+	if report.Extra["Start Date"] != "2023:03:29 10:16:47" {
+		t.Fatalf("Expected Start Date in extra, Parsed %q", report.Extra["Start Date"])
+	}
+
+	if len(report.Warnings) != 1 || report.Warnings[0] != "LibClamAV Warning: Cannot dlopen libclamunrar_iface: file not found" {
+		t.Fatalf("Expected warning, Parsed %q", report.Warnings)
+	}
+}
+
+func FuzzParser(f *testing.F) {
+	for _, obj := range TestTable {
+		f.Add(obj.input, "/quarantine_zone/a:b.exe", "Eicar-Test-Signature", false)
+	}
+	f.Add("", "C:\\q\\x", "Win.Trojan.Agent-1", true)
+	f.Add("Time: 1.0 sec\nData read: 1 MB (ratio 1.00:1)", "Time", "Sig", true)
+
+	f.Fuzz(func(t *testing.T, noise string, path string, signature string, crlf bool) {
+		//noise alone must never break the parser
+		res := NewParser().Parse([]byte(noise))
+		details := res.Details.(plugins.VirusScanResult)
+		report := details.Context.(Report)
+		infected := 0
+		for _, obj := range report.Objects {
+			if obj.Status == StatusInfected {
+				infected++
 			}
 		}
+
+		if details.Positives != infected {
+			t.Fatalf("Expected %d positives, Parsed %d", infected, details.Positives)
+		}
+
+		if details.TotalScans < details.Positives {
+			t.Fatalf("Expected at least %d scans, Parsed %d", details.Positives, details.TotalScans)
+		}
+
+		//an injected detection line must be reported exactly
+		if !validPath(path) || !validSignature(signature) {
+			return
+		}
+
+		newline := "\n"
+		if crlf {
+			newline = "\r\n"
+		}
+		line := path + ": " + signature + " FOUND"
+		output := strings.Replace(noise, "\n", newline, -1) + newline + line + newline
+
+		report = NewParser().Parse([]byte(output)).Details.(plugins.VirusScanResult).Context.(Report)
+		if report.Status != StatusInfected {
+			t.Fatalf("Expected %s, Parsed %s", StatusInfected, report.Status)
+		}
+
+		last := report.Detections[len(report.Detections)-1]
+		if last.Path != path || last.Signature != signature {
+			t.Fatalf("Expected %q %q, Parsed %q %q", path, signature, last.Path, last.Signature)
+		}
+	})
+}
+
+func validPath(path string) bool {
+	return path != "" &&
+		path == strings.TrimSpace(path) &&
+		!strings.ContainsAny(path, "\r\n") &&
+		!strings.HasPrefix(path, "ERROR:")
+}
+
+func validSignature(signature string) bool {
+	return signature != "" &&
+		!strings.ContainsAny(signature, " \t\r\n\v\f\u0085\u00a0:") &&
+		!strings.HasPrefix(signature, limitsExceededSignature)
+}
+
+func TestParserArchiveMembers(t *testing.T) {
+	output := `/quarantine_zone/sample.zip!docs/readme.txt: OK
+/quarantine_zone/sample.zip!bin/dropper.exe: Win.Trojan.Agent-1 FOUND
+/quarantine_zone/sample.zip!bin/dropper.exe: Win.Trojan.Agent-2 FOUND
+/quarantine_zone/sample.zip!empty.dat: Empty file
+
+----------- SCAN SUMMARY -----------
+Scanned files: 1
+Infected files: 1`
+
+	expected := []Object{
+		{
+			Path:      "/quarantine_zone/sample.zip!docs/readme.txt",
+			Container: "/quarantine_zone/sample.zip",
+			Member:    "docs/readme.txt",
+			Status:    StatusClean,
+		},
+		{
+			Path:       "/quarantine_zone/sample.zip!bin/dropper.exe",
+			Container:  "/quarantine_zone/sample.zip",
+			Member:     "bin/dropper.exe",
+			Status:     StatusInfected,
+			Signatures: []string{"Win.Trojan.Agent-1", "Win.Trojan.Agent-2"},
+		},
+		{
+			Path:      "/quarantine_zone/sample.zip!empty.dat",
+			Container: "/quarantine_zone/sample.zip",
+			Member:    "empty.dat",
+			Status:    StatusSkipped,
+		},
+	}
+
+	details := NewParser().Parse([]byte(output)).Details.(plugins.VirusScanResult)
+	report := details.Context.(Report)
+
+	if !reflect.DeepEqual(report.Objects, expected) {
+		t.Fatalf("Expected %+v, Parsed %+v", expected, report.Objects)
+	}
+
+	if details.Positives != 1 || details.TotalScans != 2 {
+		t.Fatalf("Expected 1 positive of 2 scans, Parsed %d of %d", details.Positives, details.TotalScans)
 	}
 }
@@ -0,0 +1,308 @@
+package clamav
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+
+	"github.com/worlvlhole/clamav-plugin/internal/cvd"
+)
+
+//ReportVersion is the version of the Report schema. It is
+//bumped whenever a field changes meaning or is removed
+const ReportVersion = 1
+
+//Status is the overall verdict of a scan
+type Status string
+
+const (
+	//StatusClean no signature matched
+	StatusClean Status = "clean"
+	//StatusInfected at least one signature matched
+	StatusInfected Status = "infected"
+	//StatusError the scanner failed or reported errors
+	StatusError Status = "error"
+	//StatusTimeout the scan did not complete in time
+	StatusTimeout Status = "timeout"
+	//StatusSkipped the scanner did not examine the file
+	StatusSkipped Status = "skipped"
+	//StatusLimitsExceeded the file was only partially
+	//scanned because it exceeded the engine limits
+	StatusLimitsExceeded Status = "limits_exceeded"
+	//StatusCancelled the caller gave up on the scan
+	//before it completed
+	StatusCancelled Status = "cancelled"
+)
+
+//MatchSourceHash marks a Report answered from the
+//hash signature index rather than by the engine
+const MatchSourceHash = "hash"
+
+//Report is the clamav specific Context of a VirusScanResult
+type Report struct {
+	Version            int               `json:"version"`
+	Status             Status            `json:"status"`
+	KnownSignatures    int64             `json:"known_signatures"`
+	EngineVersion      string            `json:"engine_version,omitempty"`
+	ScannedDirectories int               `json:"scanned_directories"`
+	ScannedFiles       int               `json:"scanned_files"`
+	InfectedFiles      int               `json:"infected_files"`
+	BytesScanned       int64             `json:"bytes_scanned"`
+	BytesRead          int64             `json:"bytes_read"`
+	Duration           time.Duration     `json:"duration"`             //nanoseconds
+	QueueWait          time.Duration     `json:"queue_wait,omitempty"` //nanoseconds waiting for a scan slot
+	Detections         []Detection       `json:"detections"`
+	Objects            []Object          `json:"objects"`
+	Errors             []string          `json:"errors,omitempty"`
+	Warnings           []string          `json:"warnings,omitempty"`
+	Databases          []cvd.Header      `json:"databases,omitempty"` //signature databases in use
+	Cached             bool              `json:"cached,omitempty"`    //answered from the result cache
+	Shared             bool              `json:"shared,omitempty"`    //shared with a concurrent scan of the same content
+	MatchSource        string            `json:"match_source,omitempty"`
+	Extra              map[string]string `json:"extra,omitempty"` //unrecognized output
+}
+
+//Detection is a single signature match reported by clamav
+type Detection struct {
+	Path      string `json:"path"`      //file or archive member the signature matched
+	Signature string `json:"signature"` //name of the matching signature
+}
+
+//Object is clamav's verdict for a single file it examined.
+//Archive members are reported as container!member
+type Object struct {
+	Path       string   `json:"path"`                //file as reported by clamav
+	Container  string   `json:"container,omitempty"` //archive holding the member
+	Member     string   `json:"member,omitempty"`    //path inside the container
+	Status     Status   `json:"status"`
+	Signatures []string `json:"signatures,omitempty"` //matching signatures, in order
+}
+
+//NewReport creates an empty Report of the current version
+func NewReport() Report {
+	return Report{
+		Version:    ReportVersion,
+		Status:     StatusSkipped,
+		Detections: []Detection{},
+		Objects:    []Object{},
+	}
+}
+
+func newObject(path string) Object {
+	obj := Object{Path: path, Status: StatusSkipped}
+	if i := strings.Index(path, "!"); i > 0 && i < len(path)-1 {
+		obj.Container, obj.Member = path[:i], path[i+1:]
+	}
+	return obj
+}
+
+//setStatus records status unless the object
+//already has a more significant one
+func (o *Object) setStatus(status Status) {
+	o.Status = worse(o.Status, status)
+}
+
+//severity orders the statuses a parsed output can produce
+var severity = map[Status]int{
+	StatusSkipped:        0,
+	StatusClean:          1,
+	StatusError:          2,
+	StatusLimitsExceeded: 3,
+	StatusInfected:       4,
+}
+
+//worse returns the more significant of the two statuses
+func worse(a, b Status) Status {
+	if severity[b] > severity[a] {
+		return b
+	}
+	return a
+}
+
+//reportOf returns the Report held in the result's Context
+func reportOf(res plugins.Result) (Report, bool) {
+	details, ok := res.Details.(plugins.VirusScanResult)
+	if !ok {
+		return Report{}, false
+	}
+
+	report, ok := details.Context.(Report)
+	return report, ok
+}
+
+//updateReport applies fn to the Report held in the
+//result's Context, if there is one
+func updateReport(res *plugins.Result, fn func(*Report)) {
+	details, ok := res.Details.(plugins.VirusScanResult)
+	if !ok {
+		return
+	}
+
+	report, ok := details.Context.(Report)
+	if !ok {
+		return
+	}
+
+	fn(&report)
+	details.Context = report
+	res.Details = details
+}
+
+//resolveStatus combines the status parsed from the scanner
+//output with the verified scanner error. It returns false
+//when the error is not a scan outcome
+func resolveStatus(report *Report, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	//a detection is a detection, even from a failed scan
+	if report.Status == StatusInfected {
+		return true
+	}
+
+	var scanErr *ScanError
+	switch {
+	case errors.Is(err, ErrCancelled):
+		report.Status = StatusCancelled
+	case errors.Is(err, ErrTimeout):
+		report.Status = StatusTimeout
+	case errors.As(err, &scanErr):
+		if report.Status != StatusLimitsExceeded {
+			report.Status = StatusError
+		}
+		if len(report.Errors) == 0 {
+			report.Errors = scanErr.Lines
+		}
+	case errors.Is(err, ErrStreamLimit), errors.Is(err, ErrCompressionRatio),
+		errors.Is(err, ErrDiskSpace):
+		report.Status = StatusLimitsExceeded
+		report.Errors = append(report.Errors, err.Error())
+	case errors.Is(err, ErrKilled):
+		report.Status = StatusError
+		report.Errors = append(report.Errors, err.Error())
+	default:
+		return false
+	}
+
+	return true
+}
+
+//summaryKeys are the lines of the clamscan scan summary
+var summaryKeys = map[string]bool{
+	"Known viruses":       true,
+	"Engine version":      true,
+	"Scanned directories": true,
+	"Scanned files":       true,
+	"Infected files":      true,
+	"Data scanned":        true,
+	"Data read":           true,
+	"Time":                true,
+}
+
+//splitSummary splits a scan summary line into its key and value.
+//Values may themselves contain colons
+func splitSummary(line string) (key string, value string, ok bool) {
+	line = strings.TrimSpace(line)
+	i := strings.Index(line, ": ")
+	if i < 0 || !summaryKeys[line[:i]] {
+		return "", "", false
+	}
+
+	return line[:i], strings.TrimSpace(line[i+2:]), true
+}
+
+//summarize stores a line from the clamscan scan summary in
+//the matching field. Lines that are not understood are kept
+//in Extra
+func (r *Report) summarize(key, value string) {
+	var ok bool
+	switch key {
+	case "Known viruses":
+		r.KnownSignatures, ok = parseInt(value)
+	case "Engine version":
+		r.EngineVersion, ok = value, true
+	case "Scanned directories":
+		r.ScannedDirectories, ok = parseCount(value)
+	case "Scanned files":
+		r.ScannedFiles, ok = parseCount(value)
+	case "Infected files":
+		r.InfectedFiles, ok = parseCount(value)
+	case "Data scanned":
+		r.BytesScanned, ok = parseSize(value)
+	case "Data read":
+		r.BytesRead, ok = parseSize(value)
+	case "Time":
+		r.Duration, ok = parseSeconds(value)
+	}
+
+	if !ok {
+		if r.Extra == nil {
+			r.Extra = map[string]string{}
+		}
+		r.Extra[key] = value
+	}
+}
+
+func parseInt(value string) (int64, bool) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	return n, err == nil
+}
+
+func parseCount(value string) (int, bool) {
+	n, err := strconv.Atoi(value)
+	return n, err == nil
+}
+
+//clamav reports sizes in binary multiples regardless
+//of whether the unit is spelled MB or MiB
+var sizeUnits = map[string]float64{
+	"B":   1,
+	"KB":  1 << 10,
+	"KiB": 1 << 10,
+	"MB":  1 << 20,
+	"MiB": 1 << 20,
+	"GB":  1 << 30,
+	"GiB": 1 << 30,
+	"TB":  1 << 40,
+	"TiB": 1 << 40,
+}
+
+//parseSize converts values like "1.50 MB (ratio 0.00:1)" to bytes
+func parseSize(value string) (int64, bool) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return 0, false
+	}
+
+	n, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	unit, ok := sizeUnits[fields[1]]
+	if !ok {
+		return 0, false
+	}
+
+	return int64(math.Round(n * unit)), true
+}
+
+//parseSeconds converts values like "15.779 sec (0 m 15 s)"
+func parseSeconds(value string) (time.Duration, bool) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 || fields[1] != "sec" {
+		return 0, false
+	}
+
+	d, err := time.ParseDuration(fields[0] + "s")
+	if err != nil {
+		return 0, false
+	}
+
+	return d, true
+}
@@ -0,0 +1,411 @@
+package clamav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/worlvlhole/maladapt/pkg/ipc"
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+	"github.com/worlvlhole/maladapt/pkg/plugin/avscan"
+	"go.opencensus.io/trace"
+
+	"github.com/worlvlhole/clamav-plugin/internal/audit"
+	"github.com/worlvlhole/clamav-plugin/internal/cvd"
+	"github.com/worlvlhole/clamav-plugin/internal/hashdb"
+	"github.com/worlvlhole/clamav-plugin/internal/logging"
+)
+
+//WarningDatabaseStale is added to the Report warnings when
+//the signature databases are older than the configured age
+const WarningDatabaseStale = "db_stale"
+
+//Engine wraps the basic Scan method. Implementations
+//scan the content of r and return the scanner's output
+type Engine interface {
+	Scan(ctx context.Context, r io.Reader) ([]byte, error)
+}
+
+//Quarantine wraps the basic OpenFile method
+type Quarantine interface {
+	OpenFile(ctx context.Context, filename string) (io.ReadCloser, error)
+}
+
+//Sizer is implemented by quarantines that know the stored,
+//usually compressed, size of a file without opening it
+type Sizer interface {
+	Size(ctx context.Context, filename string) (int64, error)
+}
+
+//Quarantines resolves the Location of a scan to the
+//Quarantine holding its file. An empty Location is
+//the plugin's own quarantine
+type Quarantines interface {
+	Quarantine(ctx context.Context, location string) (Quarantine, error)
+}
+
+//Scanner implements the plugins.Plugin interface by
+//handing quarantined files to an Engine
+type Scanner struct {
+	engine      Engine        //engine performing the scan
+	scanTimeout time.Duration //time to wait before giving up on scan, 0 never gives up
+	maxBytes    int64         //most bytes handed to the engine, 0 is unlimited
+	maxRatio    int64         //most decompressed bytes per stored byte, 0 is unlimited
+	parser      avscan.Parser //engine output parser
+	verifier    *Verifier     //engine error verifier
+	quarantines Quarantines   //quarantines by scan location
+	databases   *cvd.Monitor  //signature database monitor
+	limiter     *Limiter      //bounds concurrent scans
+	cache       *Cache        //results by content digest
+	flight      *flight       //shares concurrent scans of the same content
+	hashes      *hashdb.Index //hash signatures checked before scanning
+	metrics     *Metrics      //scan throughput, latency and verdicts
+	audit       *audit.Log    //chained record of every outcome
+}
+
+//ScannerConfig holds what a Scanner is built from. Only Engine
+//and Quarantines are required, the zero value of the others
+//leaves that feature off
+type ScannerConfig struct {
+	Engine      Engine        //engine performing the scan
+	ScanTimeout time.Duration //time to wait before giving up on scan, 0 never gives up
+	MaxBytes    int64         //most bytes handed to the engine, 0 is unlimited
+	MaxRatio    int64         //most decompressed bytes per stored byte, 0 is unlimited
+	Parser      avscan.Parser //engine output parser, NewParser if nil
+	Verifier    *Verifier     //engine error verifier, NewVerifier if nil
+	Quarantines Quarantines   //quarantines by scan location
+	Databases   *cvd.Monitor  //signature database monitor
+	Limiter     *Limiter      //bounds concurrent scans
+	Cache       *Cache        //results by content digest
+	Hashes      *hashdb.Index //hash signatures checked before scanning
+	Metrics     *Metrics      //scan throughput, latency and verdicts
+	Audit       *audit.Log    //chained record of every outcome
+}
+
+//NewScanner creates a scanner from the provided config
+func NewScanner(cfg ScannerConfig) *Scanner {
+	if cfg.Parser == nil {
+		cfg.Parser = NewParser()
+	}
+
+	if cfg.Verifier == nil {
+		cfg.Verifier = NewVerifier()
+	}
+
+	return &Scanner{
+		engine:      cfg.Engine,
+		scanTimeout: cfg.ScanTimeout,
+		maxBytes:    cfg.MaxBytes,
+		maxRatio:    cfg.MaxRatio,
+		parser:      cfg.Parser,
+		verifier:    cfg.Verifier,
+		quarantines: cfg.Quarantines,
+		databases:   cfg.Databases,
+		limiter:     cfg.Limiter,
+		cache:       cfg.Cache,
+		flight:      newFlight(),
+		hashes:      cfg.Hashes,
+		metrics:     cfg.Metrics,
+		audit:       cfg.Audit,
+	}
+}
+
+//Scan implements the Plugin interface to received Scan messages.
+//It behaves like ScanContext with a context that is never cancelled
+func (s Scanner) Scan(scan ipc.Scan) (plugins.Result, error) {
+	return s.ScanContext(context.Background(), scan)
+}
+
+//ScanContext answers scans whose digests match a deny hash signature
+//without reading the file, and repeat scans of the same content,
+//identified by the SHA-256 digest in the message, from the cache.
+//Concurrent scans of the same content share a single scan.
+//Cancelling ctx aborts the quarantine download and the engine
+//and reports the scan as cancelled
+func (s Scanner) ScanContext(ctx context.Context, scan ipc.Scan) (plugins.Result, error) {
+	//every line logged during the scan identifies it
+	ctx = logging.NewContext(ctx, logging.FromContext(ctx).WithFields(log.Fields{
+		logging.FieldScanID:   scan.ID.String(),
+		logging.FieldFilename: scan.Filename,
+		logging.FieldDigest:   sha256Digest(scan.Digests),
+		logging.FieldElapsed:  logging.Since(time.Now()),
+	}))
+
+	ctx, span := trace.StartSpan(ctx, spanName("Scan"))
+	span.AddAttributes(
+		trace.StringAttribute("scan_id", scan.ID.String()),
+		trace.StringAttribute("filename", scan.Filename),
+		trace.StringAttribute("location", scan.Location),
+	)
+
+	started := time.Now()
+	done := s.metrics.begin()
+	res, err := s.scanContext(ctx, scan)
+	done(res, err)
+
+	if auditErr := s.audit.Append(auditRecord(scan, res, err, time.Since(started))); auditErr != nil {
+		logging.FromContext(ctx).WithFields(log.Fields{"func": "ScanContext"}).Error(auditErr)
+		if err == nil {
+			res, err = plugins.Result{}, fmt.Errorf("%w: %v", ErrAudit, auditErr)
+		}
+	}
+
+	if report, ok := reportOf(res); ok && err == nil {
+		span.AddAttributes(
+			trace.StringAttribute("verdict", string(report.Status)),
+			trace.Int64Attribute("positives", int64(len(report.Detections))),
+		)
+	}
+	endSpan(span, err)
+
+	return res, err
+}
+
+//withScanTimeout bounds ctx by the scan timeout, when there is one
+func (s Scanner) withScanTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.scanTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.scanTimeout)
+}
+
+func (s Scanner) scanContext(ctx context.Context, scan ipc.Scan) (plugins.Result, error) {
+	logger := logging.FromContext(ctx).WithFields(log.Fields{"func": "ScanContext"})
+
+	if err := s.databases.Verify(); err != nil {
+		logger.Error(err)
+		return plugins.Result{}, err
+	}
+
+	//only deny signatures answer before the file is read, allow
+	//signatures wait until the content is verified and its size known
+	if signature, ok := s.hashes.Lookup(scan.Digests, hashdb.AnySize); ok && !signature.Allow {
+		logMatch(logger, signature)
+		return hashResult(scan, signature, s.databases.Headers()), nil
+	}
+
+	digest := sha256Digest(scan.Digests)
+	if res, ok := s.cache.Get(digest, databaseVersion(s.databases.Headers())); ok {
+		logger.WithField("sha256", digest).Info("Scan result cached")
+		updateReport(&res, func(report *Report) { report.Cached = true })
+		return res, nil
+	}
+
+	res, err, shared := s.flight.Do(ctx, digest, func() (plugins.Result, error) {
+		return s.scanFile(ctx, scan)
+	})
+
+	if shared {
+		//errors belong to the request that hit them, another
+		//location, filename or copy of the content may be fine
+		if !verdict(res, err) {
+			return s.scanFile(ctx, scan)
+		}
+
+		updateReport(&res, func(report *Report) { report.Shared = true })
+		return res, nil
+	}
+
+	//results are only reused from scans that reached a verdict
+	//with the databases that are still current
+	if verdict(res, err) {
+		report, _ := reportOf(res)
+		version := databaseVersion(report.Databases)
+		if version == databaseVersion(s.databases.Headers()) {
+			s.cache.Put(digest, version, res)
+		}
+	}
+
+	return res, err
+}
+
+//verdict reports whether a scan found its content clean or infected
+func verdict(res plugins.Result, err error) bool {
+	report, ok := reportOf(res)
+	return err == nil && ok && (report.Status == StatusClean || report.Status == StatusInfected)
+}
+
+//scanFile reads the file in the message from the quarantine
+//and hands it to the engine. Timeouts and scanner errors are
+//reported through the Report status rather than as errors.
+//Scans wait for a slot in the limiter first and fail with
+//ErrBusy when its queue is full
+func (s Scanner) scanFile(parent context.Context, scan ipc.Scan) (plugins.Result, error) {
+	logger := logging.FromContext(parent).WithFields(log.Fields{"func": "scanFile"})
+
+	quarantine, err := s.quarantines.Quarantine(parent, scan.Location)
+	if err != nil {
+		logger.WithField("location", scan.Location).Error(err)
+		return plugins.Result{}, err
+	}
+
+	//queued scans do not count against the scan timeout
+	wait, release, err := s.limiter.Acquire(parent)
+	if err != nil {
+		if parent.Err() != nil {
+			err = ErrCancelled
+		}
+		logger.WithField("queue_wait", wait.String()).Error(err)
+		return plugins.Result{}, err
+	}
+	defer release()
+
+	ctx, cancel := s.withScanTimeout(parent)
+	defer cancel()
+
+	//the stored size bounds what the file may decompress to
+	storedSize := int64(-1)
+	if sizer, ok := quarantine.(Sizer); ok && s.maxRatio > 0 {
+		if storedSize, err = sizer.Size(ctx, scan.Filename); err != nil {
+			if parent.Err() != nil {
+				err = ErrCancelled
+			}
+			logger.Error(err)
+			return plugins.Result{}, err
+		}
+	}
+	maxBytes, limitErr := decompressionLimit(s.maxBytes, s.maxRatio, storedSize)
+
+	//Unquarantine
+	fetched := time.Now()
+	fetchCtx, span := trace.StartSpan(ctx, spanName(StageFetch))
+	reader, err := openFile(fetchCtx, quarantine, scan.Filename)
+	endSpan(span, err)
+	s.metrics.observe(StageFetch, time.Since(fetched))
+	if err != nil {
+		if parent.Err() != nil {
+			err = ErrCancelled
+		}
+		logger.Error(err)
+		return plugins.Result{}, err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			logger.Error(err)
+		}
+	}()
+
+	logger.Info("Initiating scan")
+	verified := newDigestReader(ctx, reader, scan.Digests)
+	counted := &countReader{r: newLimitReaderErr(verified, maxBytes, limitErr)}
+	engineCtx, span := trace.StartSpan(ctx, spanName(StageScan))
+	engineCtx, times := withStageTimes(engineCtx)
+	engineCtx = withCopySize(engineCtx, maxBytes)
+	started := time.Now()
+	output, err := s.engine.Scan(engineCtx, counted)
+	//before verifyContent reads what the engine left
+	s.metrics.scanned(counted.n)
+	if times.copy > 0 {
+		s.metrics.observe(StageCopy, times.copy)
+	}
+	s.metrics.observe(StageScan, time.Since(started)-times.copy)
+	err = s.verifier.VerifyContext(ctx, err, output)
+	if err == nil {
+		err = verifyContent(counted, verified)
+	}
+	span.AddAttributes(trace.Int64Attribute("size", counted.n))
+	trace.FromContext(parent).AddAttributes(trace.Int64Attribute("size", counted.n))
+	endSpan(span, err)
+	if err != nil && parent.Err() != nil {
+		err = ErrCancelled
+	}
+
+	//a verdict for bytes other than the ones requested is no verdict
+	if errors.Is(err, ErrIntegrity) {
+		logger.Error(err)
+		return plugins.Result{}, err
+	}
+
+	databases, stale := s.databases.Headers(), s.databases.Stale()
+
+	//the content now matches its digests, so hash signatures of its size apply
+	if err == nil {
+		if signature, ok := s.hashes.Lookup(scan.Digests, counted.n); ok {
+			logMatch(logger, signature)
+			res := hashResult(scan, signature, databases)
+			updateReport(&res, func(report *Report) { report.QueueWait = wait })
+			return res, nil
+		}
+	}
+
+	parsed := time.Now()
+	_, span = trace.StartSpan(ctx, spanName(StageParse))
+	res := s.parser.Parse(output)
+	span.End()
+	s.metrics.observe(StageParse, time.Since(parsed))
+	resolved := err == nil
+	updateReport(&res, func(report *Report) {
+		resolved = resolveStatus(report, err)
+		report.Databases = databases
+		report.QueueWait = wait
+		if stale {
+			report.Warnings = append(report.Warnings, WarningDatabaseStale)
+		}
+	})
+
+	if !resolved {
+		logger.Error(err)
+		return plugins.Result{}, err
+	}
+
+	if err != nil {
+		logger.Warn(err)
+	}
+	logger.Info("Scan complete")
+
+	return res, nil
+}
+
+func logMatch(logger *log.Entry, signature hashdb.Signature) {
+	logger.WithFields(log.Fields{
+		"signature": signature.Name,
+		"source":    signature.Source,
+		"allow":     signature.Allow,
+	}).Info("Hash signature matched")
+}
+
+//hashResult is the result of a scan answered by a hash signature
+func hashResult(scan ipc.Scan, signature hashdb.Signature, databases []cvd.Header) plugins.Result {
+	report := NewReport()
+	report.MatchSource = MatchSourceHash
+	report.Databases = databases
+
+	var positives int
+	obj := newObject(scan.Filename)
+	if signature.Allow {
+		obj.setStatus(StatusClean)
+	} else {
+		positives = 1
+		obj.setStatus(StatusInfected)
+		obj.Signatures = []string{signature.Name}
+		report.Detections = append(report.Detections, Detection{
+			Path:      scan.Filename,
+			Signature: signature.Name,
+		})
+	}
+	report.Objects = append(report.Objects, obj)
+	report.Status = obj.Status
+
+	return plugins.Result{
+		Time: time.Now(),
+		Type: plugins.VirusScan,
+		Details: plugins.VirusScanResult{
+			Positives:  positives,
+			TotalScans: 1,
+			Context:    report,
+		},
+	}
+}
+
+//verifyContent reads what the engine left of the content
+//and checks the content against the requested digests
+func verifyContent(r io.Reader, verified *digestReader) error {
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+	return verified.Verify()
+}
@@ -0,0 +1,732 @@
+package clamav
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/worlvlhole/maladapt/pkg/digests"
+	"github.com/worlvlhole/maladapt/pkg/ipc"
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+
+	"github.com/worlvlhole/clamav-plugin/internal/cvd"
+	"github.com/worlvlhole/clamav-plugin/internal/hashdb"
+	"github.com/worlvlhole/clamav-plugin/internal/logging"
+)
+
+//fakeEngine returns canned output and errors
+type fakeEngine struct {
+	output []byte
+	err    error
+	wait   bool //block until the scan is cancelled
+}
+
+func (f fakeEngine) Scan(ctx context.Context, r io.Reader) ([]byte, error) {
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return nil, err
+	}
+
+	if f.wait {
+		<-ctx.Done()
+		return f.output, ctx.Err()
+	}
+
+	return f.output, f.err
+}
+
+func scanReport(t *testing.T, engine Engine, timeout time.Duration) (Report, error) {
+	quarantine := memQuarantine{"file": []byte("content")}
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: timeout, Quarantines: quarantine})
+
+	res, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "file"})
+	if err != nil {
+		return Report{}, err
+	}
+
+	return res.Details.(plugins.VirusScanResult).Context.(Report), nil
+}
+
+func TestScannerStatus(t *testing.T) {
+	tests := []struct {
+		engine Engine
+		status Status
+	}{
+		{fakeEngine{output: []byte("stream: OK")}, StatusClean},
+		{fakeEngine{output: []byte("stream: Eicar-Test-Signature FOUND")}, StatusInfected},
+		{fakeEngine{output: []byte("")}, StatusError},
+		{fakeEngine{output: []byte("/q/file: Empty file")}, StatusSkipped},
+		{fakeEngine{output: []byte("INSTREAM size limit exceeded. ERROR")}, StatusLimitsExceeded},
+		{fakeEngine{output: []byte("/q/file: Heuristics.Limits.Exceeded FOUND")}, StatusLimitsExceeded},
+		{fakeEngine{output: []byte("/q/file: Can't open file ERROR")}, StatusError},
+		{fakeEngine{err: &ScanError{ExitCode: 2, Lines: []string{"ERROR: Can't open file"}}}, StatusError},
+		{fakeEngine{err: &KilledError{Signal: os.Kill}}, StatusError},
+		{fakeEngine{output: []byte("/q/file: Eicar-Test-Signature FOUND"), err: &KilledError{Signal: os.Kill}}, StatusInfected},
+		{fakeEngine{output: []byte("/q/file: OK"), wait: true}, StatusTimeout},
+	}
+
+	for _, test := range tests {
+		report, err := scanReport(t, test.engine, 50*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Status != test.status {
+			t.Fatalf("Expected %s, Received %s for %+v", test.status, report.Status, test.engine)
+		}
+	}
+}
+
+func TestScannerNoTimeout(t *testing.T) {
+	//a zero timeout never gives up rather than giving up at once
+	report, err := scanReport(t, fakeEngine{output: []byte("stream: OK")}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Status != StatusClean {
+		t.Fatalf("Expected %s, Received %s", StatusClean, report.Status)
+	}
+}
+
+func TestScannerEngineFailure(t *testing.T) {
+	failure := errors.New("connection refused")
+	if _, err := scanReport(t, fakeEngine{err: failure}, time.Minute); err != failure {
+		t.Fatalf("Expected %v, Received %v", failure, err)
+	}
+}
+
+func TestClamscan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamscan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//stand in for clamscan that reports on the file it was given
+	script := "#!/bin/sh\nif grep -q EICAR \"$2\"; then echo \"$2: Eicar-Test-Signature FOUND\"; exit 1; fi\necho \"$2: OK\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "clamscan"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	zone := filepath.Join(dir, "zone")
+	if err := os.Mkdir(zone, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := NewClamscan("clamscan", dir, []string{"--no-summary"}, zone, 0)
+	report, err := scanReport(t, engine, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Status != StatusClean {
+		t.Fatalf("Expected %s, Received %s", StatusClean, report.Status)
+	}
+
+	output, err := engine.Scan(context.Background(), strings.NewReader(eicar))
+	if err := NewVerifier().Verify(err); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := NewParser().Parse(output).Details.(plugins.VirusScanResult).Context.(Report).Status; status != StatusInfected {
+		t.Fatalf("Expected %s, Received %s", StatusInfected, status)
+	}
+
+	files, err := ioutil.ReadDir(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 0 {
+		t.Fatalf("Expected local quarantine zone to be empty, found %d files", len(files))
+	}
+}
+
+func TestClamscanStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamscan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//stand in for clamscan that only reads stdin
+	script := "#!/bin/sh\n[ \"$2\" = - ] || exit 2\nif grep -q EICAR; then echo \"stdin: Eicar-Test-Signature FOUND\"; exit 1; fi\necho \"stdin: OK\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "clamscan"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := NewClamscanStream("clamscan", dir, []string{"--no-summary"})
+	report, err := scanReport(t, engine, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Status != StatusClean {
+		t.Fatalf("Expected %s, Received %s", StatusClean, report.Status)
+	}
+
+	output, err := engine.Scan(context.Background(), strings.NewReader(eicar))
+	if err := NewVerifier().Verify(err); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := NewParser().Parse(output).Details.(plugins.VirusScanResult).Context.(Report).Status; status != StatusInfected {
+		t.Fatalf("Expected %s, Received %s", StatusInfected, status)
+	}
+}
+
+func TestScannerMaxBytes(t *testing.T) {
+	content := []byte("content")
+	quarantine := memQuarantine{"file": content}
+	engine := fakeEngine{output: []byte("stream: OK")}
+
+	tests := []struct {
+		maxBytes int64
+		status   Status
+	}{
+		{0, StatusClean},
+		{int64(len(content)), StatusClean},
+		{int64(len(content)) - 1, StatusLimitsExceeded},
+	}
+
+	for _, test := range tests {
+		scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, MaxBytes: test.maxBytes, Quarantines: quarantine})
+		res, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "file"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status := res.Details.(plugins.VirusScanResult).Context.(Report).Status; status != test.status {
+			t.Fatalf("Expected %s, Received %s for max bytes %d", test.status, status, test.maxBytes)
+		}
+	}
+}
+
+//storedQuarantine keeps content as if it was stored in size bytes
+type storedQuarantine struct {
+	memQuarantine
+	size int64
+}
+
+func (s storedQuarantine) Size(ctx context.Context, filename string) (int64, error) {
+	return s.size, nil
+}
+
+func (s storedQuarantine) Quarantine(ctx context.Context, location string) (Quarantine, error) {
+	return s, nil
+}
+
+func TestScannerCompressionRatio(t *testing.T) {
+	quarantine := storedQuarantine{memQuarantine{"file": make([]byte, 2<<20)}, 1 << 10}
+	engine := fakeEngine{output: []byte("stream: OK")}
+
+	tests := []struct {
+		maxRatio int64
+		status   Status
+	}{
+		{0, StatusClean},
+		{100, StatusLimitsExceeded},
+		{10000, StatusClean},
+	}
+
+	for _, test := range tests {
+		scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, MaxRatio: test.maxRatio, Quarantines: quarantine})
+		res, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "file"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		report := res.Details.(plugins.VirusScanResult).Context.(Report)
+		if report.Status != test.status {
+			t.Fatalf("Expected %s, Received %s for max ratio %d", test.status, report.Status, test.maxRatio)
+		}
+
+		if test.status == StatusLimitsExceeded && !strings.Contains(strings.Join(report.Errors, "\n"), ErrCompressionRatio.Error()) {
+			t.Fatalf("Expected %q in %v", ErrCompressionRatio, report.Errors)
+		}
+	}
+}
+
+func TestDecompressionLimit(t *testing.T) {
+	tests := []struct {
+		maxBytes   int64
+		maxRatio   int64
+		storedSize int64
+		limit      int64
+		err        error
+	}{
+		{0, 0, 10, 0, ErrStreamLimit},
+		{100, 0, 10, 100, ErrStreamLimit},
+		{0, 10, -1, 0, ErrStreamLimit},
+		{0, 10, 10, minRatioBytes, ErrCompressionRatio},
+		{0, 10, 1 << 20, 10 << 20, ErrCompressionRatio},
+		{1 << 20, 10, 1 << 20, 1 << 20, ErrStreamLimit},
+		{100 << 20, 10, 1 << 20, 10 << 20, ErrCompressionRatio},
+		{0, 1 << 62, 1 << 62, 0, ErrStreamLimit},
+	}
+
+	for _, test := range tests {
+		limit, err := decompressionLimit(test.maxBytes, test.maxRatio, test.storedSize)
+		if limit != test.limit || err != test.err {
+			t.Fatalf("Expected %d %v, Received %d %v for %+v", test.limit, test.err, limit, err, test)
+		}
+	}
+}
+
+func TestClamscanDiskSpace(t *testing.T) {
+	zone, err := ioutil.TempDir("", "zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(zone)
+
+	engine := NewClamscan("clamscan", "/nonexistent", nil, zone, 1<<62)
+	report, err := scanReport(t, engine, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Status != StatusLimitsExceeded {
+		t.Fatalf("Expected %s, Received %s", StatusLimitsExceeded, report.Status)
+	}
+
+	files, err := ioutil.ReadDir(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("Expected nothing written to the zone, Received %d files", len(files))
+	}
+
+	//the zone must also hold the most the scan may copy
+	engine = NewClamscan("clamscan", "/nonexistent", nil, zone, 1)
+	ctx := withCopySize(context.Background(), 1<<62)
+	if _, err := engine.Scan(ctx, strings.NewReader("content")); !errors.Is(err, ErrDiskSpace) {
+		t.Fatalf("Expected %v, Received %v", ErrDiskSpace, err)
+	}
+}
+
+func TestScannerCancelled(t *testing.T) {
+	quarantine := memQuarantine{"file": []byte("content")}
+	scanner := NewScanner(ScannerConfig{Engine: fakeEngine{wait: true}, ScanTimeout: time.Minute, Quarantines: quarantine})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	res, err := scanner.ScanContext(ctx, ipc.Scan{ID: uuid.New(), Filename: "file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := res.Details.(plugins.VirusScanResult).Context.(Report).Status; status != StatusCancelled {
+		t.Fatalf("Expected %s, Received %s", StatusCancelled, status)
+	}
+}
+
+//stalledReader blocks in Read until released and
+//records whether it was closed during a Read
+type stalledReader struct {
+	release chan struct{}
+	closed  chan struct{}
+
+	mu              sync.Mutex
+	reading         bool
+	closedMidstream bool
+}
+
+func (r *stalledReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	r.reading = true
+	r.mu.Unlock()
+
+	<-r.release
+
+	r.mu.Lock()
+	r.reading = false
+	r.mu.Unlock()
+	return 0, io.EOF
+}
+
+func (r *stalledReader) Close() error {
+	r.mu.Lock()
+	r.closedMidstream = r.reading
+	r.mu.Unlock()
+	close(r.closed)
+	return nil
+}
+
+func TestContextReaderCancelled(t *testing.T) {
+	stalled := &stalledReader{release: make(chan struct{}), closed: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	reader := newContextReader(ctx, stalled)
+	if _, err := reader.Read(make([]byte, 16)); err != context.Canceled {
+		t.Fatalf("Expected %v, Received %v", context.Canceled, err)
+	}
+
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-stalled.closed:
+		t.Fatal("Expected Close to wait for the stalled Read")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(stalled.release)
+	select {
+	case <-stalled.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the reader to be closed once the Read returned")
+	}
+
+	stalled.mu.Lock()
+	defer stalled.mu.Unlock()
+	if stalled.closedMidstream {
+		t.Fatal("Expected the reader not to be closed during a Read")
+	}
+}
+
+func TestClamscanProcessGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamscan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//the background sleep holds clamscan's output open,
+	//so the scan only returns once the whole group is dead
+	script := "#!/bin/sh\nsleep 30 &\nwait\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "clamscan"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	engine := NewClamscanStream("clamscan", dir, nil)
+	_, err = engine.Scan(ctx, strings.NewReader("content"))
+	if !errors.Is(NewVerifier().VerifyContext(ctx, err, nil), ErrKilled) {
+		t.Fatalf("Expected %v, Received %v", ErrKilled, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("Expected the process group to be killed, scan took %s", elapsed)
+	}
+}
+
+func TestScannerDatabases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "databases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fields := "ClamAV-VDB:05 Mar 2024 04-23 -0500:27204:2053179:90:md5:dsig:raynman:1709630580"
+	data := []byte(fields + strings.Repeat(" ", cvd.HeaderSize-len(fields)))
+	if err := ioutil.WriteFile(filepath.Join(dir, "daily.cld"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	quarantine := memQuarantine{"file": []byte("content")}
+	engine := fakeEngine{output: []byte("stream: OK")}
+	monitor := cvd.NewMonitor(dir, 0, false)
+	if err := monitor.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine, Databases: monitor})
+
+	res, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	databases := res.Details.(plugins.VirusScanResult).Context.(Report).Databases
+	if len(databases) != 1 || databases[0].Name != "daily" || databases[0].Version != 27204 {
+		t.Fatalf("Expected daily version 27204, Received %+v", databases)
+	}
+}
+
+func TestScannerStaleDatabases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "databases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//built in 2018
+	fields := "ClamAV-VDB:12 Nov 2018 08-51 -0500:25113:2120562:63:md5:dsig:raynman:1542030661"
+	data := []byte(fields + strings.Repeat(" ", cvd.HeaderSize-len(fields)))
+	if err := ioutil.WriteFile(filepath.Join(dir, "daily.cvd"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	quarantine := memQuarantine{"file": []byte("content")}
+	engine := fakeEngine{output: []byte("stream: OK")}
+
+	lenient := cvd.NewMonitor(dir, 24*time.Hour, false)
+	lenient.Refresh()
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine, Databases: lenient})
+
+	res, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := res.Details.(plugins.VirusScanResult).Context.(Report)
+	if report.Status != StatusClean || len(report.Warnings) != 1 || report.Warnings[0] != WarningDatabaseStale {
+		t.Fatalf("Expected clean result with %s warning, Received %s %v", WarningDatabaseStale, report.Status, report.Warnings)
+	}
+
+	strict := cvd.NewMonitor(dir, 24*time.Hour, true)
+	strict.Refresh()
+	scanner = NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine, Databases: strict})
+
+	if _, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "file"}); !errors.Is(err, cvd.ErrStale) {
+		t.Fatalf("Expected %v, Received %v", cvd.ErrStale, err)
+	}
+}
+
+func TestScannerIntegrity(t *testing.T) {
+	content := []byte("content")
+	quarantine := memQuarantine{"file": content}
+	engine := fakeEngine{output: []byte("stream: Eicar-Test-Signature FOUND")}
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine})
+
+	md5Sum := md5.Sum(content)
+	sha256Sum := sha256.Sum256([]byte("other content"))
+	tests := []struct {
+		digests []digests.Digest
+		err     error
+	}{
+		{nil, nil},
+		{[]digests.Digest{{Algorithm: digests.MD5, Hash: md5Sum[:]}}, nil},
+		{[]digests.Digest{{Algorithm: "crc32", Hash: []byte{1, 2, 3, 4}}}, nil},
+		{[]digests.Digest{{Algorithm: digests.MD5, Hash: md5Sum[:]}, {Algorithm: digests.SHA256, Hash: sha256Sum[:]}}, ErrIntegrity},
+	}
+
+	for _, test := range tests {
+		_, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "file", Digests: test.digests})
+		if !errors.Is(err, test.err) {
+			t.Fatalf("Expected %v, Received %v for %v", test.err, err, test.digests)
+		}
+	}
+}
+
+func TestScannerHashSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sum := md5.Sum([]byte(eicar))
+	database := filepath.Join(dir, "local.hdb")
+	if err := ioutil.WriteFile(database, []byte(hex.EncodeToString(sum[:])+":*:Eicar-Test-Signature:73\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := hashdb.NewIndex([]string{database}, nil, nil)
+	if err := hashes.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the quarantine is never read
+	engine := fakeEngine{err: errors.New("engine should not run")}
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: memQuarantine{}, Hashes: hashes})
+
+	res, err := scanner.Scan(ipc.Scan{
+		ID:       uuid.New(),
+		Filename: "file",
+		Digests:  []digests.Digest{{Algorithm: digests.MD5, Hash: sum[:]}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	details := res.Details.(plugins.VirusScanResult)
+	report := details.Context.(Report)
+	if details.Positives != 1 || report.Status != StatusInfected || report.MatchSource != MatchSourceHash {
+		t.Fatalf("Expected infected hash match, Received %d positives %s %q", details.Positives, report.Status, report.MatchSource)
+	}
+
+	if len(report.Detections) != 1 || report.Detections[0].Signature != "Eicar-Test-Signature" {
+		t.Fatalf("Expected Eicar-Test-Signature detection, Received %+v", report.Detections)
+	}
+}
+
+func TestScannerHashSignatureContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	good, bad := []byte("false positive"), []byte("bad")
+	goodSum, badSum := sha256.Sum256(good), sha256.Sum256(bad)
+	database := filepath.Join(dir, "local.hsb")
+	allowlist := filepath.Join(dir, "local.sfp")
+	if err := ioutil.WriteFile(database, []byte(hex.EncodeToString(badSum[:])+":3:Bad-Signature\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(allowlist, []byte(hex.EncodeToString(goodSum[:])+":14:good.exe\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := hashdb.NewIndex([]string{dir}, nil, nil)
+	if err := hashes.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	quarantine := memQuarantine{"good": good, "bad": bad, "other": []byte("other")}
+	engine := &countingEngine{}
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine, Hashes: hashes})
+
+	tests := []struct {
+		filename string
+		sum      [sha256.Size]byte
+		status   Status
+		err      error
+	}{
+		//allowed once the content is read and matches
+		{"good", goodSum, StatusClean, nil},
+		//an allowed digest does not vouch for other content
+		{"other", goodSum, "", ErrIntegrity},
+		//sized deny signatures apply once the size is known
+		{"bad", badSum, StatusInfected, nil},
+	}
+
+	for _, test := range tests {
+		res, err := scanner.Scan(ipc.Scan{
+			ID:       uuid.New(),
+			Filename: test.filename,
+			Digests:  []digests.Digest{{Algorithm: digests.SHA256, Hash: test.sum[:]}},
+		})
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s: Expected %v, Received %v", test.filename, test.err, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		report := res.Details.(plugins.VirusScanResult).Context.(Report)
+		if report.Status != test.status || report.MatchSource != MatchSourceHash {
+			t.Fatalf("%s: Expected %s hash match, Received %s %q", test.filename, test.status, report.Status, report.MatchSource)
+		}
+	}
+
+	//every file was read, none was answered from its digests alone
+	if scans := atomic.LoadInt32(&engine.scans); scans != int32(len(tests)) {
+		t.Fatalf("Expected %d scans, Received %d", len(tests), scans)
+	}
+}
+
+func TestScannerLogFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.StandardLogger()
+	out, formatter, level := logger.Out, logger.Formatter, logger.Level
+	logger.Out, logger.Formatter, logger.Level = &buf, &log.JSONFormatter{}, log.DebugLevel
+	defer func() {
+		logger.Out, logger.Formatter, logger.Level = out, formatter, level
+	}()
+
+	content := []byte("content")
+	scan := scanDigest(content)
+	//logged while reading the file
+	scan.Digests = append(scan.Digests, digests.Digest{Algorithm: "crc32", Hash: []byte{1}})
+	quarantine := memQuarantine{"file": content}
+	scanner := NewScanner(ScannerConfig{Engine: fakeEngine{output: []byte("stream: OK")}, ScanTimeout: time.Minute, Quarantines: quarantine})
+	if _, err := scanner.Scan(scan); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for _, line := range lines {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatal(err)
+		}
+
+		if fields[logging.FieldScanID] != scan.ID.String() || fields[logging.FieldFilename] != "file" ||
+			fields[logging.FieldDigest] != sha256Digest(scan.Digests) || fields[logging.FieldElapsed] == nil {
+			t.Fatalf("Expected the scan fields in %s", line)
+		}
+	}
+
+	if !strings.Contains(buf.String(), "not verifying digest algorithm crc32") {
+		t.Fatalf("Expected the unverified digest to be logged, Received %s", buf.String())
+	}
+}
+
+func TestScannerSelfTest(t *testing.T) {
+	tests := []struct {
+		engine Engine
+		pass   bool
+	}{
+		{fakeEngine{output: []byte("stream: Eicar-Test-Signature FOUND")}, true},
+		{fakeEngine{output: []byte("stream: Win.Test.EICAR_HDB-1 FOUND")}, true},
+		{fakeEngine{output: []byte("stream: OK")}, false},
+		{fakeEngine{output: []byte("stream: Other-Signature FOUND")}, false},
+		{fakeEngine{err: &ScanError{ExitCode: 2, Lines: []string{"ERROR: Can't open the database"}}}, false},
+		{fakeEngine{err: errors.New("connection refused")}, false},
+		{fakeEngine{output: []byte("stream: Eicar-Test-Signature FOUND"), wait: true}, false},
+	}
+
+	for _, test := range tests {
+		scanner := NewScanner(ScannerConfig{Engine: test.engine, ScanTimeout: 50 * time.Millisecond, Quarantines: memQuarantine{}})
+
+		err := scanner.SelfTest(context.Background(), "eicar")
+		if test.pass && err != nil {
+			t.Fatalf("Expected %+v to pass, Received %v", test.engine, err)
+		}
+		if !test.pass && !errors.Is(err, ErrSelfTest) {
+			t.Fatalf("Expected %v for %+v, Received %v", ErrSelfTest, test.engine, err)
+		}
+	}
+}
+
+func TestScannerSelfTestLimiter(t *testing.T) {
+	limiter := NewLimiter(1, 0)
+	engine := &countingEngine{}
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Limiter: limiter})
+
+	_, release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the self-test does not run past max_parallel_scans
+	if err := scanner.SelfTest(context.Background(), "eicar"); err != ErrBusy {
+		t.Fatalf("Expected %v, Received %v", ErrBusy, err)
+	}
+	if scans := atomic.LoadInt32(&engine.scans); scans != 0 {
+		t.Fatalf("Expected no scans, Received %d", scans)
+	}
+
+	release()
+	if err := scanner.SelfTest(context.Background(), "eicar"); !errors.Is(err, ErrSelfTest) {
+		t.Fatalf("Expected %v from a clean result, Received %v", ErrSelfTest, err)
+	}
+	if limiter.Running() != 0 {
+		t.Fatalf("Expected the slot to be released, %d running", limiter.Running())
+	}
+}
@@ -0,0 +1,53 @@
+package clamav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//eicar is the EICAR anti-virus test file, detected
+//by every engine without being harmful
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+//ErrSelfTest the engine did not detect the EICAR test file
+var ErrSelfTest = errors.New("self-test failed")
+
+//SelfTest scans the EICAR test file from memory with the engine
+//and checks that a signature containing want, ignoring case, was
+//detected. It fails when the engine cannot run or load its
+//databases, and when the databases are stale in strict mode.
+//Like any scan it waits for a slot in the limiter, failing with
+//ErrBusy when the queue is full. The quarantine, cache and hash
+//signatures are skipped
+func (s Scanner) SelfTest(ctx context.Context, want string) error {
+	if err := s.databases.Verify(); err != nil {
+		return fmt.Errorf("%w: %v", ErrSelfTest, err)
+	}
+
+	_, release, err := s.limiter.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	ctx, cancel := s.withScanTimeout(ctx)
+	defer cancel()
+
+	output, err := s.engine.Scan(ctx, strings.NewReader(eicar))
+	if err := s.verifier.VerifyContext(ctx, err, output); err != nil {
+		return fmt.Errorf("%w: %v", ErrSelfTest, err)
+	}
+
+	report, _ := reportOf(s.parser.Parse(output))
+	var found []string
+	for _, detection := range report.Detections {
+		if strings.Contains(strings.ToLower(detection.Signature), strings.ToLower(want)) {
+			return nil
+		}
+		found = append(found, detection.Signature)
+	}
+
+	return fmt.Errorf("%w: expected a %q signature, found %v", ErrSelfTest, want, found)
+}
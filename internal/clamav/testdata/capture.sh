@@ -0,0 +1,76 @@
+#!/bin/sh
+# Captures clamscan and clamdscan output for the parser corpus.
+#
+#   ./capture.sh VERSION [IMAGE]
+#
+# Runs every case against IMAGE, by default the official
+# clamav/clamav:VERSION image, which is published from 0.104 on.
+# Older versions need an image built from the release source.
+# Each sample is written as <tool>-VERSION-<case>.out with a line
+# in SOURCES recording the version and command. Regenerate the
+# goldens afterwards with go test ./internal/clamav -run TestGolden -update
+# and delete the synthetic sample a capture replaces.
+set -eu
+
+version=$1
+image=${2:-clamav/clamav:$version}
+testdata=$(cd "$(dirname "$0")" && pwd)
+zone=$(mktemp -d)
+container=
+trap 'rm -rf "$zone"; [ -z "$container" ] || docker rm -f "$container" >/dev/null' EXIT
+
+# quarantined files are named by their sha256, as the plugin names them
+quarantine() {
+	name=$(sha256sum "$1" | cut -d' ' -f1)
+	mv "$1" "$zone/$name"
+	echo "/quarantine_zone/$name"
+}
+
+printf '%s' 'X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*' >"$zone/eicar"
+printf 'clean\n' >"$zone/clean"
+(cd "$zone" && zip -q -P infected encrypted.zip eicar && zip -q members.zip eicar clean)
+: >"$zone/empty.new"
+cp "$zone/clean" "$zone/denied.new"
+
+encrypted=$(quarantine "$zone/encrypted.zip")
+members=$(quarantine "$zone/members.zip")
+infected=$(quarantine "$zone/eicar")
+clean=$(quarantine "$zone/clean")
+empty=$(quarantine "$zone/empty.new")
+denied=$(quarantine "$zone/denied.new")
+chmod 000 "$zone/$(basename "$denied")"
+chmod 755 "$zone"
+
+# capture TOOL CASE ARGS... runs TOOL in a new container
+capture() {
+	tool=$1 name=$2
+	shift 2
+	out="$tool-$version-$name.out"
+	docker run --rm --user clamav -v "$zone:/quarantine_zone:ro" --entrypoint "$tool" "$image" "$@" \
+		>"$testdata/$out" 2>&1 || true
+	printf '%s\t%s\t%s %s\tcaptured %s from %s\n' "$out" "$version" "$tool" "$*" "$(date -u +%F)" "$image" \
+		>>"$testdata/SOURCES"
+}
+
+capture clamscan clean "$clean"
+capture clamscan infected "$infected"
+capture clamscan empty-file "$empty"
+capture clamscan encrypted-archive --alert-encrypted=yes "$encrypted"
+capture clamscan limits-exceeded --max-filesize=1 --alert-exceeds-max=yes "$clean"
+capture clamscan archive-members --allmatch "$members"
+capture clamscan missing-db --database=/nonexistent "$clean"
+capture clamscan permission-denied "$denied"
+
+# clamdscan talks to the clamd started by the image's entrypoint
+container=$(docker run -d -v "$zone:/quarantine_zone:ro" "$image")
+until docker exec "$container" clamdscan "$clean" >/dev/null 2>&1; do
+	sleep 5
+done
+
+for name in clean infected; do
+	eval target=\$$name
+	out="clamdscan-$version-$name.out"
+	docker exec "$container" clamdscan "$target" >"$testdata/$out" 2>&1 || true
+	printf '%s\t%s\tclamdscan %s\tcaptured %s from %s\n' "$out" "$version" "$target" "$(date -u +%F)" "$image" \
+		>>"$testdata/SOURCES"
+done
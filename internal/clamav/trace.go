@@ -0,0 +1,18 @@
+package clamav
+
+import (
+	"go.opencensus.io/trace"
+)
+
+//spanName names the span of a stage
+func spanName(stage string) string {
+	return "clamav." + stage
+}
+
+//endSpan ends span, marking it failed when err is set
+func endSpan(span *trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+	}
+	span.End()
+}
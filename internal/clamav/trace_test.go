@@ -0,0 +1,103 @@
+package clamav
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/worlvlhole/maladapt/pkg/ipc"
+	"go.opencensus.io/trace"
+)
+
+//spanRecorder keeps every exported span
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(span *trace.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+func (r *spanRecorder) byName() map[string]*trace.SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	spans := map[string]*trace.SpanData{}
+	for _, span := range r.spans {
+		spans[span.Name] = span
+	}
+	return spans
+}
+
+func TestScannerSpans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := "#!/bin/sh\nif grep -q EICAR \"$2\"; then echo \"$2: Eicar-Test-Signature FOUND\"; exit 1; fi\necho \"$2: OK\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "clamscan"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &spanRecorder{}
+	trace.RegisterExporter(recorder)
+	defer trace.UnregisterExporter(recorder)
+
+	quarantine := memQuarantine{"file": []byte(eicar)}
+	engine := NewClamscan("clamscan", dir, []string{"--no-summary"}, dir, 0)
+	scanner := NewScanner(ScannerConfig{Engine: engine, ScanTimeout: time.Minute, Quarantines: quarantine})
+
+	ctx, parent := trace.StartSpan(context.Background(), "parent", trace.WithSampler(trace.AlwaysSample()))
+	id := uuid.New()
+	if _, err := scanner.ScanContext(ctx, ipc.Scan{ID: id, Filename: "file"}); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := recorder.byName()
+	scan, ok := spans["clamav.Scan"]
+	if !ok {
+		t.Fatalf("Expected a clamav.Scan span, Received %v", spans)
+	}
+
+	if scan.ParentSpanID != parent.SpanContext().SpanID {
+		t.Fatal("Expected clamav.Scan to be a child of the caller's span")
+	}
+
+	for key, value := range map[string]interface{}{
+		"scan_id": id.String(),
+		"verdict": string(StatusInfected),
+		"size":    int64(len(eicar)),
+	} {
+		if scan.Attributes[key] != value {
+			t.Fatalf("Expected %s %v, Received %v", key, value, scan.Attributes[key])
+		}
+	}
+
+	//copy runs inside the engine, the other stages inside the scan
+	parents := map[string]string{
+		"clamav.fetch": "clamav.Scan",
+		"clamav.scan":  "clamav.Scan",
+		"clamav.copy":  "clamav.scan",
+		"clamav.parse": "clamav.Scan",
+	}
+	for name, parentName := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("Expected a %s span", name)
+		}
+		if span.TraceID != scan.TraceID || span.ParentSpanID != spans[parentName].SpanID {
+			t.Fatalf("Expected %s to be a child of %s", name, parentName)
+		}
+	}
+}
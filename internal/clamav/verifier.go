@@ -1,7 +1,66 @@
 package clamav
 
-//clamav exits with this status code when malware is detected
-var virusFoundExitCode = "exit status 1"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+const (
+	//clamscan exits with this status code when malware is detected
+	virusFoundExitCode = 1
+)
+
+var (
+	//ErrScanError clamscan exited reporting errors, e.g. files
+	//it could not read or a database it could not load
+	ErrScanError = errors.New("clamscan reported errors")
+	//ErrKilled clamscan was terminated by a signal
+	ErrKilled = errors.New("clamscan was killed")
+	//ErrTimeout the scan did not complete before its deadline
+	ErrTimeout = errors.New("scan timed out")
+	//ErrCancelled the caller cancelled the scan or
+	//its deadline passed before the scan completed
+	ErrCancelled = errors.New("scan cancelled")
+)
+
+//ScanError is returned when clamscan exits with a status
+//other than 0 or 1. It matches ErrScanError
+type ScanError struct {
+	ExitCode int      //clamscan exit status
+	Lines    []string //error lines printed by clamscan
+}
+
+func (e *ScanError) Error() string {
+	if len(e.Lines) == 0 {
+		return fmt.Sprintf("%s: exit status %d", ErrScanError, e.ExitCode)
+	}
+	return fmt.Sprintf("%s: exit status %d: %s", ErrScanError, e.ExitCode, strings.Join(e.Lines, "; "))
+}
+
+//Is reports whether target is ErrScanError
+func (e *ScanError) Is(target error) bool {
+	return target == ErrScanError
+}
+
+//KilledError is returned when clamscan is terminated
+//by a signal. It matches ErrKilled
+type KilledError struct {
+	Signal os.Signal //signal that terminated clamscan
+}
+
+func (e *KilledError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrKilled, e.Signal)
+}
+
+//Is reports whether target is ErrKilled
+func (e *KilledError) Is(target error) bool {
+	return target == ErrKilled
+}
 
 //Verifier verifies exit codes from clamav
 type Verifier struct{}
@@ -16,14 +75,50 @@ func NewVerifier() *Verifier {
 //code 1 if the scan was positive, we don't want to acutally
 //consider this an error
 func (v Verifier) Verify(err error) error {
+	return v.VerifyContext(context.Background(), err, nil)
+}
+
+//VerifyContext behaves like Verify. The context the scan ran
+//under is used to tell timeouts apart from other kills, and
+//error lines are taken from output when clamscan's stderr was
+//not captured separately
+func (v Verifier) VerifyContext(ctx context.Context, err error, output []byte) error {
 	if err == nil {
 		return nil
 	}
 
-	switch err.Error() {
+	if errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return &KilledError{Signal: status.Signal()}
+	}
+
+	switch exitErr.ExitCode() {
 	case virusFoundExitCode:
 		return nil
 	default:
-		return err
+		if len(exitErr.Stderr) > 0 {
+			output = exitErr.Stderr
+		}
+		return &ScanError{ExitCode: exitErr.ExitCode(), Lines: errorLines(output)}
+	}
+}
+
+//errorLines returns the lines of clamscan output
+//that report an error
+func errorLines(output []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if isErrorLine(line) {
+			lines = append(lines, strings.TrimSpace(line))
+		}
 	}
+	return lines
 }
@@ -0,0 +1,92 @@
+package clamav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func run(ctx context.Context, script string) ([]byte, error) {
+	return exec.CommandContext(ctx, "/bin/sh", "-c", script).Output()
+}
+
+func TestVerifier(t *testing.T) {
+	verifier := NewVerifier()
+
+	if err := verifier.Verify(nil); err != nil {
+		t.Fatalf("Expected nil, Verified %v", err)
+	}
+
+	//virus found
+	_, err := run(context.Background(), "exit 1")
+	if err := verifier.Verify(err); err != nil {
+		t.Fatalf("Expected nil, Verified %v", err)
+	}
+
+	if err := verifier.Verify(fmt.Errorf("clamscan: %w", err)); err != nil {
+		t.Fatalf("Expected nil for wrapped error, Verified %v", err)
+	}
+
+	//scan errors
+	_, err = run(context.Background(), `echo "ERROR: Can't open file /q/x" >&2; exit 2`)
+	err = verifier.Verify(err)
+	if !errors.Is(err, ErrScanError) {
+		t.Fatalf("Expected %v, Verified %v", ErrScanError, err)
+	}
+
+	var scanErr *ScanError
+	if !errors.As(err, &scanErr) || scanErr.ExitCode != 2 {
+		t.Fatalf("Expected exit code 2, Verified %v", err)
+	}
+
+	if len(scanErr.Lines) != 1 || scanErr.Lines[0] != "ERROR: Can't open file /q/x" {
+		t.Fatalf("Expected error lines, Verified %q", scanErr.Lines)
+	}
+
+	//killed
+	_, err = run(context.Background(), "kill -TERM $$")
+	err = verifier.Verify(err)
+	var killErr *KilledError
+	if !errors.Is(err, ErrKilled) || !errors.As(err, &killErr) || killErr.Signal != syscall.SIGTERM {
+		t.Fatalf("Expected %v, Verified %v", ErrKilled, err)
+	}
+
+	//other errors pass through
+	other := errors.New("exec: not found")
+	if err := verifier.Verify(other); err != other {
+		t.Fatalf("Expected %v, Verified %v", other, err)
+	}
+}
+
+func TestVerifierTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	output, err := run(ctx, "exec sleep 5")
+	if err := NewVerifier().VerifyContext(ctx, err, output); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected %v, Verified %v", ErrTimeout, err)
+	}
+
+	if err := NewVerifier().Verify(context.DeadlineExceeded); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected %v, Verified %v", ErrTimeout, err)
+	}
+}
+
+func TestVerifierOutput(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", `echo "/q/x: Can't open file ERROR"; echo "/q/y: OK"; exit 2`)
+	output, err := cmd.CombinedOutput()
+
+	var scanErr *ScanError
+	err = NewVerifier().VerifyContext(context.Background(), err, output)
+	if !errors.As(err, &scanErr) {
+		t.Fatalf("Expected %v, Verified %v", ErrScanError, err)
+	}
+
+	if len(scanErr.Lines) != 1 || scanErr.Lines[0] != "/q/x: Can't open file ERROR" {
+		t.Fatalf("Expected error lines, Verified %q", scanErr.Lines)
+	}
+}
@@ -0,0 +1,27 @@
+//Package closer closes the layers of a stream together
+package closer
+
+import "io"
+
+//ReadCloser reads from a Reader and closes every Closer,
+//e.g. a gzip reader and the remote file beneath it
+type ReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+//NewReadCloser creates a ReadCloser of r closing closers in order
+func NewReadCloser(r io.Reader, closers ...io.Closer) *ReadCloser {
+	return &ReadCloser{Reader: r, closers: closers}
+}
+
+//Close closes every closer, returning the first error
+func (r *ReadCloser) Close() error {
+	var first error
+	for _, closer := range r.closers {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
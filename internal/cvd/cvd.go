@@ -0,0 +1,154 @@
+//Package cvd reads the headers of ClamAV signature
+//databases (.cvd and .cld files)
+package cvd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//HeaderSize is the length of a CVD/CLD header
+	HeaderSize = 512
+
+	magic = "ClamAV-VDB"
+	//build times are written as "%d %b %Y %H-%M %z"
+	buildTimeLayout = "02 Jan 2006 15-04 -0700"
+)
+
+//Databases are the signature databases published by ClamAV
+var Databases = []string{"main", "daily", "bytecode"}
+
+var (
+	//ErrInvalidHeader the file is not a ClamAV signature database
+	ErrInvalidHeader = errors.New("invalid cvd header")
+	//ErrNoDatabases no signature database was found
+	ErrNoDatabases = errors.New("no signature databases found")
+)
+
+//Header describes a single signature database
+type Header struct {
+	Name               string    `json:"name"`                //database name, e.g. daily
+	File               string    `json:"file"`                //file the header was read from
+	Version            int       `json:"version"`             //database version
+	BuildTime          time.Time `json:"build_time"`          //time the database was built
+	Signatures         int64     `json:"signatures"`          //number of signatures
+	FunctionalityLevel int       `json:"functionality_level"` //minimum engine functionality level
+	MD5                string    `json:"md5,omitempty"`       //digest of the database body
+	Builder            string    `json:"builder"`             //who built the database
+}
+
+//ReadHeader parses a CVD/CLD header from r
+func ReadHeader(r io.Reader) (Header, error) {
+	buf := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return Header{}, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+
+	//ClamAV-VDB:time:version:sigs:flevel:md5:dsig:builder:stime
+	fields := strings.Split(strings.TrimRight(string(bytes.TrimRight(buf, "\x00")), " "), ":")
+	if len(fields) < 8 || fields[0] != magic {
+		return Header{}, ErrInvalidHeader
+	}
+
+	version, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return Header{}, fmt.Errorf("%w: version %q", ErrInvalidHeader, fields[2])
+	}
+
+	sigs, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return Header{}, fmt.Errorf("%w: signatures %q", ErrInvalidHeader, fields[3])
+	}
+
+	flevel, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return Header{}, fmt.Errorf("%w: functionality level %q", ErrInvalidHeader, fields[4])
+	}
+
+	header := Header{
+		Version:            version,
+		Signatures:         sigs,
+		FunctionalityLevel: flevel,
+		MD5:                fields[5],
+		Builder:            fields[7],
+	}
+
+	//prefer the unix build time, older databases only have the text one
+	if len(fields) > 8 {
+		if stime, err := strconv.ParseInt(fields[8], 10, 64); err == nil {
+			header.BuildTime = time.Unix(stime, 0).UTC()
+		}
+	}
+
+	if header.BuildTime.IsZero() {
+		header.BuildTime, err = time.Parse(buildTimeLayout, fields[1])
+		if err != nil {
+			return Header{}, fmt.Errorf("%w: build time %q", ErrInvalidHeader, fields[1])
+		}
+		header.BuildTime = header.BuildTime.UTC()
+	}
+
+	return header, nil
+}
+
+//ReadFile reads the header of the database file at path
+func ReadFile(path string) (Header, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer file.Close()
+
+	header, err := ReadHeader(file)
+	if err != nil {
+		return Header{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	base := filepath.Base(path)
+	header.Name = strings.TrimSuffix(base, filepath.Ext(base))
+	header.File = path
+
+	return header, nil
+}
+
+//Load reads the headers of the main, daily and bytecode databases
+//in dir. When both a .cvd and .cld exist the newer version is used,
+//as freshclam leaves the .cld behind after applying diffs
+func Load(dir string) ([]Header, error) {
+	var headers []Header
+	for _, name := range Databases {
+		var newest *Header
+		for _, ext := range []string{".cvd", ".cld"} {
+			header, err := ReadFile(filepath.Join(dir, name+ext))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if newest == nil || header.Version > newest.Version {
+				h := header
+				newest = &h
+			}
+		}
+
+		if newest != nil {
+			headers = append(headers, *newest)
+		}
+	}
+
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("%s: %w", dir, ErrNoDatabases)
+	}
+
+	return headers, nil
+}
@@ -0,0 +1,180 @@
+package cvd
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//header builds a CVD header padded to HeaderSize
+func header(fields string) []byte {
+	return append([]byte(fields), bytes.Repeat([]byte(" "), HeaderSize-len(fields))...)
+}
+
+func writeDatabase(t *testing.T, dir, file, fields string) {
+	data := append(header(fields), []byte("body")...)
+	if err := ioutil.WriteFile(filepath.Join(dir, file), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		fields   string
+		expected Header
+	}{
+		{
+			"ClamAV-VDB:12 Nov 2018 08-51 -0500:25113:2120562:63:d0f0b5b8c1a1e6ad5e5f0f2b9a1c9a7e:dsig:raynman:1542030661",
+			Header{
+				Version:            25113,
+				BuildTime:          time.Unix(1542030661, 0).UTC(),
+				Signatures:         2120562,
+				FunctionalityLevel: 63,
+				MD5:                "d0f0b5b8c1a1e6ad5e5f0f2b9a1c9a7e",
+				Builder:            "raynman",
+			},
+		},
+		{
+			//no unix build time
+			"ClamAV-VDB:16 Sep 2021 08-32 +0000:62:6647427:90:1f2e3d4c5b6a79881f2e3d4c5b6a7988:dsig:sigmgr",
+			Header{
+				Version:            62,
+				BuildTime:          time.Date(2021, 9, 16, 8, 32, 0, 0, time.UTC),
+				Signatures:         6647427,
+				FunctionalityLevel: 90,
+				MD5:                "1f2e3d4c5b6a79881f2e3d4c5b6a7988",
+				Builder:            "sigmgr",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		parsed, err := ReadHeader(bytes.NewReader(header(test.fields)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed != test.expected {
+			t.Fatalf("Expected %+v, Parsed %+v", test.expected, parsed)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"not a database",
+		"ClamAV-VDB:12 Nov 2018 08-51 -0500:x:2120562:63:md5:dsig:raynman",
+	}
+
+	for _, fields := range invalid {
+		r := strings.NewReader(fields)
+		if fields != "" {
+			r = strings.NewReader(string(header(fields)))
+		}
+
+		if _, err := ReadHeader(r); !errors.Is(err, ErrInvalidHeader) {
+			t.Fatalf("Expected %v for %q, Received %v", ErrInvalidHeader, fields, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cvd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := Load(dir); !errors.Is(err, ErrNoDatabases) {
+		t.Fatalf("Expected %v, Received %v", ErrNoDatabases, err)
+	}
+
+	writeDatabase(t, dir, "main.cvd", "ClamAV-VDB:09 Jan 2024 13-57 -0500:62:6647427:90:md5:dsig:sigmgr:1704826620")
+	writeDatabase(t, dir, "daily.cvd", "ClamAV-VDB:01 Mar 2024 04-24 -0500:27200:2052000:90:md5:dsig:raynman:1709285040")
+	writeDatabase(t, dir, "daily.cld", "ClamAV-VDB:05 Mar 2024 04-23 -0500:27204:2053179:90:md5:dsig:raynman:1709630580")
+
+	headers, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(headers) != 2 {
+		t.Fatalf("Expected 2 databases, Loaded %d", len(headers))
+	}
+
+	if headers[0].Name != "main" || headers[0].Version != 62 {
+		t.Fatalf("Expected main version 62, Loaded %s version %d", headers[0].Name, headers[0].Version)
+	}
+
+	if headers[1].Name != "daily" || headers[1].Version != 27204 || filepath.Base(headers[1].File) != "daily.cld" {
+		t.Fatalf("Expected daily.cld version 27204, Loaded %s version %d", headers[1].File, headers[1].Version)
+	}
+
+	writeDatabase(t, dir, "bytecode.cvd", "garbage")
+	if _, err := Load(dir); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("Expected %v, Received %v", ErrInvalidHeader, err)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cvd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	built := time.Unix(1709630580, 0)
+	writeDatabase(t, dir, "main.cvd", "ClamAV-VDB:09 Jan 2024 13-57 -0500:62:6647427:90:md5:dsig:sigmgr:1704826620")
+	writeDatabase(t, dir, "daily.cld", "ClamAV-VDB:05 Mar 2024 04-23 -0500:27204:2053179:90:md5:dsig:raynman:1709630580")
+
+	monitor := NewMonitor(dir, 24*time.Hour, true)
+	monitor.now = func() time.Time { return built.Add(time.Hour) }
+
+	//nothing loaded yet
+	if !monitor.Stale() {
+		t.Fatal("Expected unloaded databases to be stale")
+	}
+
+	if err := monitor.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the age comes from the newest database, not main
+	if monitor.Age() != time.Hour {
+		t.Fatalf("Expected age of %s, Received %s", time.Hour, monitor.Age())
+	}
+
+	if monitor.Stale() || monitor.Verify() != nil {
+		t.Fatal("Expected databases to be current")
+	}
+
+	monitor.now = func() time.Time { return built.Add(48 * time.Hour) }
+	if !monitor.Stale() {
+		t.Fatal("Expected databases to be stale")
+	}
+
+	if err := monitor.Verify(); !errors.Is(err, ErrStale) {
+		t.Fatalf("Expected %v, Received %v", ErrStale, err)
+	}
+
+	//only strict monitors fail verification
+	lenient := NewMonitor(dir, 24*time.Hour, false)
+	lenient.now = monitor.now
+	if err := lenient.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !lenient.Stale() || lenient.Verify() != nil {
+		t.Fatal("Expected stale databases to pass lenient verification")
+	}
+
+	//a nil monitor is never stale
+	var disabled *Monitor
+	if disabled.Stale() || disabled.Verify() != nil || disabled.Headers() != nil {
+		t.Fatal("Expected nil monitor to be disabled")
+	}
+}
@@ -0,0 +1,141 @@
+package cvd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//ErrStale the newest signature database is older than allowed
+var ErrStale = errors.New("signature databases are stale")
+
+//Monitor keeps the headers of the signature databases in a
+//directory and reports when they are older than maxAge. A
+//Monitor with a zero maxAge never considers databases stale.
+//All methods are safe to call on a nil Monitor
+type Monitor struct {
+	dir    string
+	maxAge time.Duration
+	strict bool
+	now    func() time.Time
+
+	mu      sync.RWMutex
+	headers []Header
+	err     error
+}
+
+//NewMonitor creates a monitor for the databases in dir.
+//In strict mode Verify fails once the databases are stale
+func NewMonitor(dir string, maxAge time.Duration, strict bool) *Monitor {
+	return &Monitor{
+		dir:    dir,
+		maxAge: maxAge,
+		strict: strict,
+		now:    time.Now,
+	}
+}
+
+//Refresh reloads the database headers
+func (m *Monitor) Refresh() error {
+	if m == nil {
+		return nil
+	}
+
+	headers, err := Load(m.dir)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+	if err == nil {
+		m.headers = headers
+	}
+
+	return err
+}
+
+//Headers returns the most recently loaded headers
+func (m *Monitor) Headers() []Header {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.headers
+}
+
+//Age returns how long ago the newest database was built. Only
+//the newest matters as main is rebuilt far less often than daily
+func (m *Monitor) Age() time.Duration {
+	var newest time.Time
+	for _, header := range m.Headers() {
+		if header.BuildTime.After(newest) {
+			newest = header.BuildTime
+		}
+	}
+
+	if newest.IsZero() {
+		return 0
+	}
+
+	return m.now().Sub(newest)
+}
+
+//Stale reports whether the databases are older than maxAge.
+//Databases that could not be loaded are considered stale
+func (m *Monitor) Stale() bool {
+	if m == nil || m.maxAge == 0 {
+		return false
+	}
+
+	m.mu.RLock()
+	failed := m.err != nil || len(m.headers) == 0
+	m.mu.RUnlock()
+
+	return failed || m.Age() > m.maxAge
+}
+
+//Verify returns ErrStale when the databases are stale
+//and the monitor is in strict mode
+func (m *Monitor) Verify() error {
+	if m == nil || !m.strict || !m.Stale() {
+		return nil
+	}
+
+	return fmt.Errorf("%w: newest database is %s old, limit %s", ErrStale, m.Age().Round(time.Minute), m.maxAge)
+}
+
+//Watch refreshes the headers every interval until ctx is done,
+//logging whenever the databases become or stop being stale
+func (m *Monitor) Watch(ctx context.Context, interval time.Duration) {
+	logger := log.WithFields(log.Fields{"func": "Watch", "dir": m.dir})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	stale := m.Stale()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := m.Refresh(); err != nil {
+			logger.Error(err)
+		}
+
+		current := m.Stale()
+		switch {
+		case current && !stale:
+			logger.WithField("age", m.Age().String()).Warn("signature databases are stale")
+		case !current && stale:
+			logger.Info("signature databases are current")
+		}
+		stale = current
+	}
+}
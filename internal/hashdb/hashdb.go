@@ -0,0 +1,370 @@
+//Package hashdb indexes ClamAV hash signatures (.hdb, .hsb)
+//and allow and deny lists of hashes, so scans can be answered
+//from the digests and size of a file
+package hashdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/worlvlhole/maladapt/pkg/digests"
+)
+
+//DefaultDenyName names deny list entries that do not name themselves
+const DefaultDenyName = "Maladapt.Denylist"
+
+//reloads are delayed until files stop changing
+const reloadDelay = time.Second
+
+//hash signature files in a database directory. ClamAV
+//reads .fp and .sfp as allow lists of the same format
+var (
+	denyExtensions  = []string{".hdb", ".hsb"}
+	allowExtensions = []string{".fp", ".sfp"}
+)
+
+//supported digest algorithms by hex length
+var hexLengths = map[digests.Algorithm]int{
+	digests.MD5:    32,
+	digests.SHA1:   40,
+	digests.SHA256: 64,
+}
+
+//AnySize is the size of signatures matching files of every
+//size, and the size to look up when a file's size is unknown
+const AnySize = -1
+
+//Signature is an indexed hash
+type Signature struct {
+	Name   string `json:"name"`
+	Source string `json:"source"` //file the hash was loaded from
+	Allow  bool   `json:"allow"`  //the hash is known good
+	Size   int64  `json:"size"`   //size of the hashed file, AnySize for every size
+}
+
+//matches reports whether the signature matches a file of size
+//bytes. Files of unknown size only match signatures of any size
+func (s Signature) matches(size int64) bool {
+	return s.Size == AnySize || s.Size == size
+}
+
+//Index holds the hashes of the configured files in memory.
+//Files may also be directories of .hdb, .hsb, .fp and .sfp
+//files. All methods are safe to call on a nil Index
+type Index struct {
+	databases  []string
+	allowlists []string
+	denylists  []string
+
+	mu         sync.RWMutex
+	signatures map[string][]Signature //by lower case hex hash
+	len        int
+}
+
+//NewIndex creates an index of the hash databases
+//and allow and deny lists. It is empty until loaded
+func NewIndex(databases, allowlists, denylists []string) *Index {
+	return &Index{
+		databases:  databases,
+		allowlists: allowlists,
+		denylists:  denylists,
+		signatures: map[string][]Signature{},
+	}
+}
+
+//Load reads every file and replaces the index. The
+//index is left unchanged when any file fails to load
+func (i *Index) Load() error {
+	if i == nil {
+		return nil
+	}
+
+	signatures := map[string][]Signature{}
+	for _, path := range i.databases {
+		if err := loadPath(signatures, path); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range i.denylists {
+		if err := loadFile(signatures, path, false); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range i.allowlists {
+		if err := loadFile(signatures, path, true); err != nil {
+			return err
+		}
+	}
+
+	var n int
+	for _, matches := range signatures {
+		n += len(matches)
+	}
+
+	i.mu.Lock()
+	i.signatures, i.len = signatures, n
+	i.mu.Unlock()
+
+	return nil
+}
+
+//Lookup returns the signature matching the digests of a file of
+//size bytes. A deny signature is overridden by an allow signature
+//of an equally strong or stronger hash, so a weak MD5 allow entry
+//never hides a SHA-256 deny entry. When size is AnySize, deny
+//signatures must be of any size to match and allow signatures of
+//every size match, so a deny found before the file is read is
+//never overridden once its size is known
+func (i *Index) Lookup(scanDigests []digests.Digest, size int64) (Signature, bool) {
+	if i == nil {
+		return Signature{}, false
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var deny, allow Signature
+	var denyStrength, allowStrength int
+	for _, digest := range scanDigests {
+		length, ok := hexLengths[strings.ToLower(digest.Algorithm)]
+		key := hex.EncodeToString(digest.Hash)
+		if !ok || len(key) != length {
+			continue
+		}
+
+		for _, signature := range i.signatures[key] {
+			switch {
+			case signature.Allow && (size == AnySize || signature.matches(size)):
+				if length > allowStrength {
+					allow, allowStrength = signature, length
+				}
+			case !signature.Allow && signature.matches(size):
+				if length > denyStrength {
+					deny, denyStrength = signature, length
+				}
+			}
+		}
+	}
+
+	switch {
+	case denyStrength > allowStrength:
+		return deny, true
+	case allowStrength > 0:
+		return allow, true
+	default:
+		return Signature{}, false
+	}
+}
+
+//Len returns the number of indexed hashes
+func (i *Index) Len() int {
+	if i == nil {
+		return 0
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.len
+}
+
+//Watch reloads the index whenever one of its files changes
+//until ctx is done. Directories are watched rather than files
+//as freshclam and editors replace files instead of writing them
+func (i *Index) Watch(ctx context.Context) error {
+	if i == nil {
+		return nil
+	}
+
+	logger := log.WithFields(log.Fields{"func": "Watch"})
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	for dir := range i.dirs() {
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+	}
+
+	reload := time.NewTimer(reloadDelay)
+	reload.Stop()
+	defer reload.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-watcher.Events:
+			if i.watches(event.Name) {
+				reload.Reset(reloadDelay)
+			}
+		case err := <-watcher.Errors:
+			logger.Error(err)
+		case <-reload.C:
+			if err := i.Load(); err != nil {
+				logger.Error(err)
+				continue
+			}
+			logger.WithField("hashes", i.Len()).Info("hash signatures reloaded")
+		}
+	}
+}
+
+//dirs returns the directories holding the index's files
+func (i *Index) dirs() map[string]bool {
+	dirs := map[string]bool{}
+	for _, path := range i.paths() {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dirs[filepath.Clean(path)] = true
+			continue
+		}
+		dirs[filepath.Dir(path)] = true
+	}
+	return dirs
+}
+
+//watches reports whether a change to name changes the index
+func (i *Index) watches(name string) bool {
+	name = filepath.Clean(name)
+	for _, path := range i.paths() {
+		path = filepath.Clean(path)
+		if name == path {
+			return true
+		}
+
+		if filepath.Dir(name) == path && (hasExtension(name, denyExtensions) || hasExtension(name, allowExtensions)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Index) paths() []string {
+	paths := make([]string, 0, len(i.databases)+len(i.allowlists)+len(i.denylists))
+	paths = append(paths, i.databases...)
+	paths = append(paths, i.allowlists...)
+	return append(paths, i.denylists...)
+}
+
+//loadPath loads a hash database, or every hash
+//database and allow list in a directory
+func loadPath(signatures map[string][]Signature, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return loadFile(signatures, path, hasExtension(path, allowExtensions))
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := filepath.Join(path, file.Name())
+		switch {
+		case file.IsDir():
+		case hasExtension(name, denyExtensions):
+			if err := loadFile(signatures, name, false); err != nil {
+				return err
+			}
+		case hasExtension(name, allowExtensions):
+			if err := loadFile(signatures, name, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//loadFile adds the hashes in the file to signatures. Lines
+//are either ClamAV's "hash:size:name[:flevel]", where size
+//may be "*" for any size, or a bare hash optionally followed
+//by whitespace and a name, which matches any size
+func loadFile(signatures map[string][]Signature, path string, allow bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, size, name, err := parseLine(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, number, err)
+		}
+
+		if name == "" && !allow {
+			name = DefaultDenyName
+		}
+
+		signatures[hash] = append(signatures[hash], Signature{Name: name, Source: path, Allow: allow, Size: size})
+	}
+
+	return scanner.Err()
+}
+
+//parseLine returns the lower case hex hash, the size and the name of a line
+func parseLine(line string) (hash string, size int64, name string, err error) {
+	size = AnySize
+	if fields := strings.Split(line, ":"); len(fields) >= 3 {
+		hash, name = fields[0], fields[2]
+		if fields[1] != "*" {
+			if size, err = strconv.ParseInt(fields[1], 10, 64); err != nil || size < 0 {
+				return "", 0, "", fmt.Errorf("invalid size %q", fields[1])
+			}
+		}
+	} else if fields := strings.Fields(line); len(fields) > 0 {
+		hash = fields[0]
+		name = strings.TrimSpace(strings.TrimPrefix(line, hash))
+	}
+
+	hash = strings.ToLower(hash)
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", 0, "", fmt.Errorf("invalid hash %q", hash)
+	}
+
+	switch len(hash) {
+	case hexLengths[digests.MD5], hexLengths[digests.SHA1], hexLengths[digests.SHA256]:
+	default:
+		return "", 0, "", fmt.Errorf("unsupported hash length %d", len(hash))
+	}
+
+	return hash, size, name, nil
+}
+
+func hasExtension(name string, extensions []string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, extension := range extensions {
+		if ext == extension {
+			return true
+		}
+	}
+	return false
+}
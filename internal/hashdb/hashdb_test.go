@@ -0,0 +1,152 @@
+package hashdb
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/worlvlhole/maladapt/pkg/digests"
+)
+
+var (
+	eicarMD5    = md5.Sum([]byte("eicar"))
+	eicarSHA256 = sha256.Sum256([]byte("eicar"))
+	goodSHA256  = sha256.Sum256([]byte("good"))
+	badSHA256   = sha256.Sum256([]byte("bad"))
+	badMD5      = md5.Sum([]byte("bad"))
+)
+
+func write(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write(t, filepath.Join(dir, "local.hdb"), "# md5 signatures\n"+hex.EncodeToString(eicarMD5[:])+":68:Eicar-Test-Signature\n")
+	write(t, filepath.Join(dir, "local.hsb"), hex.EncodeToString(eicarSHA256[:])+":*:Eicar-Test-Signature.SHA:73\n"+
+		hex.EncodeToString(goodSHA256[:])+":4:Win.Trojan.FalsePositive\n")
+	write(t, filepath.Join(dir, "local.sfp"), hex.EncodeToString(goodSHA256[:])+":4:good.exe\n")
+	write(t, filepath.Join(dir, "local.fp"), hex.EncodeToString(badMD5[:])+":3:bad.exe\n")
+
+	denylist := filepath.Join(dir, "deny.txt")
+	write(t, denylist, hex.EncodeToString(badSHA256[:])+"\n")
+
+	index := NewIndex([]string{dir}, nil, []string{denylist})
+	if err := index.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	md5Digest := func(sum [md5.Size]byte) digests.Digest { return digests.Digest{Algorithm: digests.MD5, Hash: sum[:]} }
+	sha256Digest := func(sum [sha256.Size]byte) digests.Digest {
+		return digests.Digest{Algorithm: digests.SHA256, Hash: sum[:]}
+	}
+
+	tests := []struct {
+		digests   []digests.Digest
+		size      int64
+		found     bool
+		signature Signature
+	}{
+		{[]digests.Digest{md5Digest(eicarMD5)}, 68, true,
+			Signature{Name: "Eicar-Test-Signature", Source: filepath.Join(dir, "local.hdb"), Size: 68}},
+		//a collision on a file of another size is no match
+		{[]digests.Digest{md5Digest(eicarMD5)}, 67, false, Signature{}},
+		{[]digests.Digest{md5Digest(eicarMD5)}, AnySize, false, Signature{}},
+		{[]digests.Digest{sha256Digest(eicarSHA256)}, 5, true,
+			Signature{Name: "Eicar-Test-Signature.SHA", Source: filepath.Join(dir, "local.hsb"), Size: AnySize}},
+		{[]digests.Digest{sha256Digest(goodSHA256)}, 4, true,
+			Signature{Name: "good.exe", Source: filepath.Join(dir, "local.sfp"), Allow: true, Size: 4}},
+		{[]digests.Digest{sha256Digest(goodSHA256)}, AnySize, true,
+			Signature{Name: "good.exe", Source: filepath.Join(dir, "local.sfp"), Allow: true, Size: 4}},
+		{[]digests.Digest{sha256Digest(badSHA256)}, AnySize, true,
+			Signature{Name: DefaultDenyName, Source: denylist, Size: AnySize}},
+		{[]digests.Digest{md5Digest(badMD5)}, 3, true,
+			Signature{Name: "bad.exe", Source: filepath.Join(dir, "local.fp"), Allow: true, Size: 3}},
+		//a weaker allow hash never overrides a stronger deny hash
+		{[]digests.Digest{md5Digest(badMD5), sha256Digest(badSHA256)}, 3, true,
+			Signature{Name: DefaultDenyName, Source: denylist, Size: AnySize}},
+		{[]digests.Digest{{Algorithm: digests.MD5, Hash: eicarSHA256[:]}}, 68, false, Signature{}},
+		{[]digests.Digest{{Algorithm: digests.SHA512, Hash: eicarSHA256[:]}}, 68, false, Signature{}},
+	}
+
+	for _, test := range tests {
+		signature, found := index.Lookup(test.digests, test.size)
+		if found != test.found || signature != test.signature {
+			t.Fatalf("Expected %t %+v for %d bytes, Received %t %+v", test.found, test.signature, test.size, found, signature)
+		}
+	}
+
+	if index.Len() != 6 {
+		t.Fatalf("Expected 6 hashes, Received %d", index.Len())
+	}
+}
+
+func TestIndexInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "local.hdb")
+	write(t, path, hex.EncodeToString(eicarMD5[:])+":68:Eicar-Test-Signature\n")
+	index := NewIndex([]string{path}, nil, nil)
+	if err := index.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	//a broken file leaves the loaded index in place
+	for _, line := range []string{"not a hash:68:Broken", hex.EncodeToString(eicarMD5[:]) + ":big:Broken"} {
+		write(t, path, line+"\n")
+		if err := index.Load(); err == nil {
+			t.Fatalf("Expected an error loading %q", line)
+		}
+	}
+
+	if index.Len() != 1 {
+		t.Fatalf("Expected 1 hash, Received %d", index.Len())
+	}
+}
+
+func TestIndexWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	allowlist := filepath.Join(dir, "allow.txt")
+	write(t, allowlist, "")
+	index := NewIndex([]string{dir}, []string{allowlist}, nil)
+	if err := index.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go index.Watch(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	write(t, filepath.Join(dir, "daily.hdb"), hex.EncodeToString(eicarMD5[:])+":68:Eicar-Test-Signature\n")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for index.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the index to reload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//Fields added to the logger of every scan
+const (
+	FieldScanID   = "scan_id"
+	FieldFilename = "filename"
+	FieldDigest   = "sha256"
+	FieldElapsed  = "elapsed"
+)
+
+type loggerKey struct{}
+
+//NewContext returns a context carrying logger
+func NewContext(ctx context.Context, logger *log.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+//FromContext returns the logger carried by ctx,
+//or the standard logger when it carries none
+func FromContext(ctx context.Context) *log.Entry {
+	if logger, ok := ctx.Value(loggerKey{}).(*log.Entry); ok {
+		return logger
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+//Elapsed is a field value that formats as the time since it
+//was created, so every line logged with it reports how far
+//into the scan it was written
+type Elapsed time.Time
+
+//Since creates an Elapsed from start
+func Since(start time.Time) Elapsed {
+	return Elapsed(start)
+}
+
+//String implements fmt.Stringer for the text formatter
+func (e Elapsed) String() string {
+	return time.Since(time.Time(e)).String()
+}
+
+//MarshalJSON implements json.Marshaler for the JSON formatters
+func (e Elapsed) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.String())
+}
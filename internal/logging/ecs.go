@@ -0,0 +1,44 @@
+package logging
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+//ecsVersion is the Elastic Common Schema version the lines follow
+const ecsVersion = "1.6.0"
+
+//ecsFormatter writes JSON lines with the field names of
+//the Elastic Common Schema. Fields of the entry are kept
+//as they are, ECS allows custom fields at the top level
+type ecsFormatter struct {
+	json *log.JSONFormatter
+}
+
+func newECSFormatter() *ecsFormatter {
+	return &ecsFormatter{json: &log.JSONFormatter{
+		TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
+		FieldMap: log.FieldMap{
+			log.FieldKeyTime:  "@timestamp",
+			log.FieldKeyMsg:   "message",
+			log.FieldKeyLevel: "log.level",
+		},
+	}}
+}
+
+//Format implements log.Formatter. The entry is copied
+//as it may be shared with other hooks and formatters
+func (f *ecsFormatter) Format(entry *log.Entry) ([]byte, error) {
+	data := make(log.Fields, len(entry.Data)+2)
+	for k, v := range entry.Data {
+		data[k] = v
+	}
+	data["ecs.version"] = ecsVersion
+	if fn, ok := data["func"]; ok {
+		delete(data, "func")
+		data["log.origin.function"] = fn
+	}
+
+	ecs := *entry
+	ecs.Data = data
+	return f.json.Format(&ecs)
+}
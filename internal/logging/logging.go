@@ -0,0 +1,210 @@
+//Package logging configures the standard logrus logger and
+//carries scan scoped loggers through a context
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	lSyslog "github.com/sirupsen/logrus/hooks/syslog"
+	"github.com/spf13/viper"
+)
+
+const (
+	//FormatJSON writes logrus JSON lines
+	FormatJSON string = "json"
+	//FormatText writes logrus key=value lines
+	FormatText string = "text"
+	//FormatECS writes JSON lines in the Elastic Common Schema
+	FormatECS string = "ecs"
+
+	//OutputStderr writes to stderr, which go-plugin forwards to the host
+	OutputStderr string = "stderr"
+	//OutputFile appends to the configured file
+	OutputFile string = "file"
+	//OutputSyslog sends to syslog with the configured tag
+	OutputSyslog string = "syslog"
+
+	defaultLevel     = "info"
+	defaultSyslogTag = "clamav"
+)
+
+//Configuration defines the level, format and
+//destinations of the plugin's logs
+type Configuration struct {
+	Level         string
+	Format        string
+	Outputs       []string
+	File          string
+	SyslogTag     string
+	SyslogAddress string
+}
+
+//NewConfigurationFromViper creates a Configuration from the values
+//provided by the viper instance
+func NewConfigurationFromViper(cfg *viper.Viper) Configuration {
+	return NewConfiguration(
+		cfg.GetString("log.level"),
+		cfg.GetString("log.format"),
+		cfg.GetStringSlice("log.outputs"),
+		cfg.GetString("log.file"),
+		cfg.GetString("log.syslog_tag"),
+		cfg.GetString("log.syslog_address"),
+	)
+}
+
+//NewConfiguration creates a new Configuration from the provided values.
+//By default logs are JSON at info level to stderr and syslog
+func NewConfiguration(level, format string, outputs []string,
+	file string,
+	syslogTag string,
+	syslogAddress string,
+) Configuration {
+	if level == "" {
+		level = defaultLevel
+	}
+
+	if format == "" {
+		format = FormatJSON
+	}
+
+	if len(outputs) == 0 {
+		outputs = []string{OutputStderr, OutputSyslog}
+	}
+
+	if syslogTag == "" {
+		syslogTag = defaultSyslogTag
+	}
+
+	for i, output := range outputs {
+		outputs[i] = strings.ToLower(strings.TrimSpace(output))
+	}
+
+	return Configuration{
+		Level:         strings.ToLower(level),
+		Format:        strings.ToLower(format),
+		Outputs:       outputs,
+		File:          file,
+		SyslogTag:     syslogTag,
+		SyslogAddress: syslogAddress,
+	}
+}
+
+//Validate implements the Validate interface.
+func (c *Configuration) Validate() error {
+	if _, err := log.ParseLevel(c.Level); err != nil {
+		return err
+	}
+
+	switch c.Format {
+	case FormatJSON, FormatText, FormatECS:
+	default:
+		return fmt.Errorf("invalid log format %q", c.Format)
+	}
+
+	for _, output := range c.Outputs {
+		switch output {
+		case OutputStderr, OutputSyslog:
+		case OutputFile:
+			if c.File == "" {
+				return errors.New("log file is empty")
+			}
+		default:
+			return fmt.Errorf("invalid log output %q", output)
+		}
+	}
+
+	return nil
+}
+
+//Setup applies c to the standard logger. The returned func
+//closes the log file. A syslog that cannot be reached is
+//logged rather than failing, as it was before it was optional
+func Setup(c Configuration) (func() error, error) {
+	logger := log.StandardLogger()
+	closer := func() error { return nil }
+
+	var writers []io.Writer
+	var useSyslog bool
+	for _, output := range c.Outputs {
+		switch output {
+		case OutputStderr:
+			writers = append(writers, os.Stderr)
+		case OutputFile:
+			file, err := os.OpenFile(c.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+			if err != nil {
+				return nil, err
+			}
+			writers = append(writers, file)
+			closer = file.Close
+		case OutputSyslog:
+			useSyslog = true
+		default:
+			return nil, fmt.Errorf("invalid log output %q", output)
+		}
+	}
+
+	level, err := log.ParseLevel(c.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	formatter, err := newFormatter(c.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.SetLevel(level)
+	logger.SetFormatter(formatter)
+	switch len(writers) {
+	case 0:
+		logger.SetOutput(ioutil.Discard)
+	case 1:
+		logger.SetOutput(writers[0])
+	default:
+		logger.SetOutput(io.MultiWriter(writers...))
+	}
+
+	if useSyslog {
+		network, raddr := splitAddress(c.SyslogAddress)
+		hook, err := lSyslog.NewSyslogHook(network, raddr, syslog.LOG_DEBUG, c.SyslogTag)
+		if err != nil {
+			log.WithField("address", c.SyslogAddress).Error("could not setup syslog logger")
+		} else {
+			logger.AddHook(hook)
+		}
+	}
+
+	return closer, nil
+}
+
+func newFormatter(format string) (log.Formatter, error) {
+	switch format {
+	case FormatJSON:
+		return &log.JSONFormatter{}, nil
+	case FormatText:
+		return &log.TextFormatter{DisableColors: true, FullTimestamp: true}, nil
+	case FormatECS:
+		return newECSFormatter(), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+//splitAddress splits a syslog address such as udp://host:514.
+//An empty address is the local syslog
+func splitAddress(address string) (network string, raddr string) {
+	if i := strings.Index(address, "://"); i > 0 {
+		return address[:i], address[i+3:]
+	}
+	if address != "" {
+		return "udp", address
+	}
+	return "", ""
+}
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestConfiguration(t *testing.T) {
+	tests := []struct {
+		cfg   Configuration
+		valid bool
+	}{
+		{NewConfiguration("", "", nil, "", "", ""), true},
+		{NewConfiguration("DEBUG", "ECS", []string{"Stderr"}, "", "", ""), true},
+		{NewConfiguration("warn", "text", []string{"file"}, "/var/log/clamav-plugin.log", "", ""), true},
+		{NewConfiguration("loud", "", nil, "", "", ""), false},
+		{NewConfiguration("", "xml", nil, "", "", ""), false},
+		{NewConfiguration("", "", []string{"file"}, "", "", ""), false},
+		{NewConfiguration("", "", []string{"stdout"}, "", "", ""), false},
+	}
+
+	for _, test := range tests {
+		if err := test.cfg.Validate(); (err == nil) != test.valid {
+			t.Fatalf("Expected valid %v, Received %v for %+v", test.valid, err, test.cfg)
+		}
+	}
+
+	cfg := NewConfiguration("", "", nil, "", "", "")
+	if cfg.SyslogTag != defaultSyslogTag || len(cfg.Outputs) != 2 {
+		t.Fatalf("Unexpected defaults %+v", cfg)
+	}
+}
+
+func format(t *testing.T, formatter log.Formatter, entry *log.Entry) map[string]interface{} {
+	line, err := formatter.Format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		t.Fatalf("%v: %s", err, line)
+	}
+	return fields
+}
+
+func TestECSFormatter(t *testing.T) {
+	entry := log.NewEntry(log.New()).WithFields(log.Fields{"func": "scanFile", FieldScanID: "id"})
+	entry.Message = "Scan complete"
+	entry.Level = log.InfoLevel
+	entry.Time = time.Now()
+
+	fields := format(t, newECSFormatter(), entry)
+	for key, value := range map[string]interface{}{
+		"message":             "Scan complete",
+		"log.level":           "info",
+		"log.origin.function": "scanFile",
+		"ecs.version":         ecsVersion,
+		FieldScanID:           "id",
+	} {
+		if fields[key] != value {
+			t.Fatalf("Expected %s %v, Received %v", key, value, fields[key])
+		}
+	}
+
+	if _, ok := fields["@timestamp"]; !ok {
+		t.Fatalf("Expected @timestamp in %v", fields)
+	}
+
+	//the entry itself is left alone for other hooks
+	if _, ok := entry.Data["ecs.version"]; ok {
+		t.Fatal("Expected the entry to be unchanged")
+	}
+}
+
+func TestContextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+	logger.Formatter = &log.JSONFormatter{}
+
+	start := time.Now().Add(-time.Second)
+	ctx := NewContext(context.Background(), log.NewEntry(logger).WithFields(log.Fields{
+		FieldScanID:  "id",
+		FieldElapsed: Since(start),
+	}))
+	FromContext(ctx).WithFields(log.Fields{"func": "scanFile"}).Info("Initiating scan")
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	if fields[FieldScanID] != "id" || fields["func"] != "scanFile" {
+		t.Fatalf("Expected the scan fields, Received %v", fields)
+	}
+
+	elapsed, err := time.ParseDuration(fields[FieldElapsed].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed < time.Second {
+		t.Fatalf("Expected at least 1s elapsed, Received %s", elapsed)
+	}
+
+	if text := Since(start).String(); !strings.HasSuffix(text, "s") {
+		t.Fatalf("Expected a duration, Received %q", text)
+	}
+
+	if FromContext(context.Background()).Logger != log.StandardLogger() {
+		t.Fatal("Expected the standard logger without a scan logger")
+	}
+}
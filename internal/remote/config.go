@@ -0,0 +1,102 @@
+package remote
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/spf13/viper"
+)
+
+//options of a remote may also be set in the
+//environment, e.g. MAL_REMOTE_S3Q_SECRET_ACCESS_KEY
+const envPrefix = "MAL_REMOTE_"
+
+//Remote is an rclone remote configured through the plugin's
+//configuration rather than an rclone config file
+type Remote struct {
+	Name    string
+	Options map[string]string //rclone options, type is required
+}
+
+//RemotesFromViper creates the remotes named by the "remotes" key.
+//The options of a remote come from the "remote.<name>" map and
+//from MAL_REMOTE_<NAME>_<OPTION> environment variables, which
+//take precedence so credentials need not be written to a file.
+//Passwords of crypt remotes are given obscured, as rclone expects
+func RemotesFromViper(cfg *viper.Viper) []Remote {
+	names := cfg.GetStringSlice("remotes")
+	remotes := make([]Remote, 0, len(names))
+	for _, name := range names {
+		options := map[string]string{}
+		for key, value := range cfg.GetStringMapString("remote." + name) {
+			options[strings.ToLower(key)] = value
+		}
+
+		prefix := envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1)) + "_"
+		for _, env := range os.Environ() {
+			i := strings.Index(env, "=")
+			if i < 0 || !strings.HasPrefix(env[:i], prefix) {
+				continue
+			}
+			options[strings.ToLower(strings.TrimPrefix(env[:i], prefix))] = env[i+1:]
+		}
+
+		remotes = append(remotes, Remote{Name: name, Options: options})
+	}
+
+	return remotes
+}
+
+//Validate checks the remote has a name and a type
+//with a backend compiled into the plugin
+func (r Remote) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("remote name is empty")
+	}
+
+	backend, ok := r.Options["type"]
+	if !ok || backend == "" {
+		return fmt.Errorf("remote %q has no type", r.Name)
+	}
+
+	if _, err := fs.Find(backend); err != nil {
+		return fmt.Errorf("remote %q has unknown type %q, available types are %s",
+			r.Name, backend, strings.Join(Backends(), ", "))
+	}
+
+	return nil
+}
+
+//Register validates the remotes and makes them available
+//to rclone. rclone reads remotes from the environment, so
+//their options are exported as RCLONE_CONFIG_ variables
+func Register(remotes []Remote) error {
+	for _, r := range remotes {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range remotes {
+		for key, value := range r.Options {
+			if err := os.Setenv(fs.ConfigToEnv(r.Name, key), value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//Backends returns the names of the backends compiled into the plugin
+func Backends() []string {
+	backends := make([]string, 0, len(fs.Registry))
+	for _, info := range fs.Registry {
+		backends = append(backends, info.Name)
+	}
+	sort.Strings(backends)
+	return backends
+}
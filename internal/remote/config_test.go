@@ -0,0 +1,54 @@
+package remote
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	_ "github.com/ncw/rclone/backend/local"
+	"github.com/ncw/rclone/fs"
+	"github.com/spf13/viper"
+)
+
+func TestRemotesFromViper(t *testing.T) {
+	cfg := viper.New()
+	cfg.Set("remotes", []string{"tenant-a"})
+	cfg.Set("remote.tenant-a", map[string]string{"type": "local", "nounc": "true"})
+
+	os.Setenv("MAL_REMOTE_TENANT_A_TYPE", "swift")
+	os.Setenv("MAL_REMOTE_TENANT_A_KEY", "secret")
+	defer os.Unsetenv("MAL_REMOTE_TENANT_A_TYPE")
+	defer os.Unsetenv("MAL_REMOTE_TENANT_A_KEY")
+
+	remotes := RemotesFromViper(cfg)
+	if len(remotes) != 1 || remotes[0].Name != "tenant-a" {
+		t.Fatalf("Expected remote tenant-a, Received %+v", remotes)
+	}
+
+	//the environment takes precedence over the config
+	options := remotes[0].Options
+	if options["type"] != "swift" || options["key"] != "secret" || options["nounc"] != "true" {
+		t.Fatalf("Unexpected options %v", options)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	err := Register([]Remote{{Name: "quarantine", Options: map[string]string{"type": "s4"}}})
+	if err == nil || !strings.Contains(err.Error(), `unknown type "s4"`) {
+		t.Fatalf("Expected unknown type error, Received %v", err)
+	}
+
+	if err := Register([]Remote{{Name: "quarantine"}}); err == nil {
+		t.Fatal("Expected missing type error")
+	}
+
+	remotes := []Remote{{Name: "registered", Options: map[string]string{"type": "local"}}}
+	if err := Register(remotes); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(fs.ConfigToEnv("registered", "type"))
+
+	if _, err := fs.NewFs("registered:" + os.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+}
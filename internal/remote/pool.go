@@ -0,0 +1,214 @@
+//Package remote resolves the Location of a scan request
+//to a quarantine on an rclone remote
+package remote
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ncw/rclone/fs"
+	log "github.com/sirupsen/logrus"
+	"github.com/worlvlhole/maladapt/pkg/quarantine"
+
+	"github.com/worlvlhole/clamav-plugin/internal/clamav"
+	"github.com/worlvlhole/clamav-plugin/internal/logging"
+	"github.com/worlvlhole/clamav-plugin/internal/sealed"
+)
+
+//ErrNotAllowed the location is not on an allowed remote
+var ErrNotAllowed = errors.New("location is not allowed")
+
+//MaxQuarantines is the number of open quarantines a Pool
+//keeps, beyond it the least recently used is dropped
+const MaxQuarantines = 256
+
+//Pool resolves locations to quarantines, keeping one fs.Fs
+//for each of the MaxQuarantines most recently used locations
+type Pool struct {
+	config    quarantine.Configuration //default quarantine
+	allowlist []string                 //permitted locations and their children
+	keyring   *sealed.Keyring          //keys of sealed quarantines
+	newFs     func(path string) (fs.Fs, error)
+	max       int
+
+	mu          sync.Mutex
+	quarantines map[string]*list.Element //location to poolEntry
+	order       *list.List               //most recently used first
+	opening     map[string]*opening      //locations being opened
+}
+
+type poolEntry struct {
+	location   string
+	quarantine clamav.Quarantine
+}
+
+//opening is a location being opened, callers asking for it
+//meanwhile wait for done rather than opening it again
+type opening struct {
+	done       chan struct{}
+	quarantine clamav.Quarantine
+	err        error
+}
+
+//NewPool creates a pool serving the quarantine in config for
+//an empty location. Other locations must be on the allowlist,
+//e.g. "swift:tenant-a" allows "swift:tenant-a" and
+//"swift:tenant-a/2018" and "s3:" allows every s3 bucket.
+//The keyring is only needed by sealed quarantines
+func NewPool(config quarantine.Configuration, allowlist []string, keyring *sealed.Keyring) *Pool {
+	return &Pool{
+		config:      config,
+		allowlist:   allowlist,
+		keyring:     keyring,
+		newFs:       fs.NewFs,
+		max:         MaxQuarantines,
+		quarantines: map[string]*list.Element{},
+		order:       list.New(),
+		opening:     map[string]*opening{},
+	}
+}
+
+//Quarantine implements clamav.Quarantines
+func (p *Pool) Quarantine(ctx context.Context, location string) (clamav.Quarantine, error) {
+	if location == "" {
+		location = p.config.Path
+	}
+
+	if !p.Allowed(location) {
+		return nil, fmt.Errorf("%w: %q", ErrNotAllowed, location)
+	}
+
+	p.mu.Lock()
+	if element, ok := p.quarantines[location]; ok {
+		p.order.MoveToFront(element)
+		p.mu.Unlock()
+		return element.Value.(*poolEntry).quarantine, nil
+	}
+
+	//opening a remote makes network calls, so it happens
+	//outside the lock and only once per location
+	o, ok := p.opening[location]
+	if ok {
+		p.mu.Unlock()
+		select {
+		case <-o.done:
+			return o.quarantine, o.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	o = &opening{done: make(chan struct{})}
+	p.opening[location] = o
+	p.mu.Unlock()
+
+	o.quarantine, o.err = p.open(ctx, location)
+
+	p.mu.Lock()
+	delete(p.opening, location)
+	if o.err == nil {
+		p.add(location, o.quarantine)
+	}
+	p.mu.Unlock()
+	close(o.done)
+
+	return o.quarantine, o.err
+}
+
+//add keeps q as the quarantine of location, dropping the
+//least recently used beyond the maximum. Callers hold p.mu
+func (p *Pool) add(location string, q clamav.Quarantine) {
+	p.quarantines[location] = p.order.PushFront(&poolEntry{location: location, quarantine: q})
+
+	for p.order.Len() > p.max {
+		oldest := p.order.Back()
+		p.order.Remove(oldest)
+		delete(p.quarantines, oldest.Value.(*poolEntry).location)
+	}
+}
+
+//open creates the quarantine of location on its remote
+func (p *Pool) open(ctx context.Context, location string) (clamav.Quarantine, error) {
+	switch p.config.Type {
+	case quarantine.Zip:
+	case sealed.Type:
+		if p.keyring == nil {
+			return nil, errors.New("sealed quarantine has no keyring")
+		}
+	default:
+		return nil, fmt.Errorf("unknown quarantine type %q", p.config.Type)
+	}
+
+	f, err := p.newFs(location)
+	if err == fs.ErrorNotFoundInConfigFile {
+		return nil, fmt.Errorf("remote of %q is not configured, add it to remotes", location)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).WithFields(log.Fields{"func": "Quarantine"}).
+		WithField("location", location).Info("Opened quarantine remote")
+
+	var q clamav.Quarantine
+	if p.config.Type == sealed.Type {
+		q = sealed.NewQuarantine(f, p.keyring)
+	} else {
+		q = zipQuarantine{fs: f}
+	}
+	return storedQuarantine{Quarantine: q, fs: f}, nil
+}
+
+//Allowed reports whether location is the default quarantine
+//or on the allowlist. Locations may not climb out of an
+//allowed location with ".."
+func (p *Pool) Allowed(location string) bool {
+	if location == p.config.Path {
+		return true
+	}
+
+	for _, element := range strings.FieldsFunc(location, isSeparator) {
+		if element == ".." {
+			return false
+		}
+	}
+
+	for _, allowed := range p.allowlist {
+		switch {
+		case allowed == "":
+		case location == allowed:
+			return true
+		case !strings.HasPrefix(location, allowed):
+		case isSeparator(rune(allowed[len(allowed)-1])):
+			return true
+		case isSeparator(rune(location[len(allowed)])):
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSeparator(r rune) bool {
+	return r == '/' || r == ':'
+}
+
+//storedQuarantine reports the stored size of files so the
+//scanner can bound how far they may decompress
+type storedQuarantine struct {
+	clamav.Quarantine
+	fs fs.Fs
+}
+
+//Size implements clamav.Sizer. Remotes that do not know
+//the size of an object report -1
+func (q storedQuarantine) Size(ctx context.Context, filename string) (int64, error) {
+	obj, err := q.fs.NewObject(filename)
+	if err != nil {
+		return 0, err
+	}
+	return obj.Size(), nil
+}
@@ -0,0 +1,188 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/ncw/rclone/backend/local"
+	"github.com/ncw/rclone/fs"
+	"github.com/worlvlhole/maladapt/pkg/quarantine"
+
+	"github.com/worlvlhole/clamav-plugin/internal/clamav"
+)
+
+func TestPoolAllowed(t *testing.T) {
+	pool := NewPool(quarantine.NewConfiguration("/quarantine", quarantine.Zip),
+		[]string{"swift:tenant-a", "s3:", "/srv/quarantine/"}, nil)
+
+	tests := []struct {
+		location string
+		allowed  bool
+	}{
+		{"/quarantine", true},
+		{"swift:tenant-a", true},
+		{"swift:tenant-a/2018", true},
+		{"swift:tenant-ab", false},
+		{"swift:tenant-b", false},
+		{"s3:any-bucket", true},
+		{"/srv/quarantine/tenant-c", true},
+		{"/srv/quarantine/../etc", false},
+		{"swift:tenant-a/../tenant-b", false},
+		{"/tmp", false},
+	}
+
+	for _, test := range tests {
+		if allowed := pool.Allowed(test.location); allowed != test.allowed {
+			t.Fatalf("Expected %s allowed %t, Received %t", test.location, test.allowed, allowed)
+		}
+	}
+}
+
+func TestPoolQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tenantA, tenantB := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	for _, tenant := range []string{tenantA, tenantB} {
+		if err := os.Mkdir(tenant, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool := NewPool(quarantine.NewConfiguration(tenantA, quarantine.Zip), []string{tenantB}, nil)
+	var opened int
+	pool.newFs = func(path string) (fs.Fs, error) {
+		opened++
+		return fs.NewFs(path)
+	}
+
+	//write a file to tenant b through its own quarantine
+	f, err := fs.NewFs(tenantB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := quarantine.NewQuarantine(quarantine.NewConfiguration(tenantB, quarantine.Zip), f).
+		Write(context.Background(), "file", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		q, err := pool.Quarantine(context.Background(), tenantB)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := q.OpenFile(context.Background(), "file")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(content) != "content" {
+			t.Fatalf("Expected content, Received %q", content)
+		}
+
+		//the stored size is the gzipped size
+		size, err := q.(clamav.Sizer).Size(context.Background(), "file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size <= 0 || size == int64(len(content)) {
+			t.Fatalf("Expected the stored size, Received %d", size)
+		}
+	}
+
+	if opened != 1 {
+		t.Fatalf("Expected the remote to be opened once, opened %d times", opened)
+	}
+
+	if _, err := pool.Quarantine(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.Quarantine(context.Background(), dir); !errors.Is(err, ErrNotAllowed) {
+		t.Fatalf("Expected %v, Received %v", ErrNotAllowed, err)
+	}
+}
+
+func TestPoolQuarantineConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pool := NewPool(quarantine.NewConfiguration(dir, quarantine.Zip), []string{"/srv/"}, nil)
+	pool.max = 2
+
+	var mu sync.Mutex
+	opened := map[string]int{}
+	release := make(chan struct{})
+	pool.newFs = func(path string) (fs.Fs, error) {
+		<-release
+		mu.Lock()
+		opened[path]++
+		mu.Unlock()
+		return fs.NewFs(dir)
+	}
+
+	//a slow remote does not hold up other locations
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.Quarantine(context.Background(), "/srv/slow"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	for {
+		pool.mu.Lock()
+		_, ok := pool.opening["/srv/slow"]
+		pool.mu.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	//callers waiting on an open give up with their context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.Quarantine(ctx, "/srv/slow"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected %v, Received %v", context.Canceled, err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	for _, location := range []string{"/srv/a", "/srv/b", "/srv/slow"} {
+		if _, err := pool.Quarantine(context.Background(), location); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//slow was opened once, then evicted by a and b
+	if opened["/srv/slow"] != 2 {
+		t.Fatalf("Expected /srv/slow opened twice, opened %d times", opened["/srv/slow"])
+	}
+	if len(pool.quarantines) != 2 || pool.order.Len() != 2 {
+		t.Fatalf("Expected 2 quarantines, Received %d", len(pool.quarantines))
+	}
+}
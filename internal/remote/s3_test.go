@@ -0,0 +1,134 @@
+package remote
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/ncw/rclone/backend/s3"
+	"github.com/ncw/rclone/fs"
+	"github.com/worlvlhole/maladapt/pkg/ipc"
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+	"github.com/worlvlhole/maladapt/pkg/quarantine"
+
+	"github.com/worlvlhole/clamav-plugin/internal/clamav"
+)
+
+//eicar is the EICAR anti-virus test file
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+//fakeS3 serves gzipped objects from a bucket of a path
+//style S3 endpoint, enough for rclone to open them
+func fakeS3(t *testing.T, bucket string, objects map[string][]byte) *httptest.Server {
+	stored := map[string][]byte{}
+	for key, content := range objects {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(content); err != nil {
+			t.Fatal(err)
+		}
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+		stored["/"+bucket+"/"+key] = buf.Bytes()
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		data, ok := stored[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		switch r.Method {
+		case http.MethodHead:
+		case http.MethodGet:
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+//streamEngine reports EICAR found in the content it is handed
+type streamEngine struct{}
+
+func (streamEngine) Scan(ctx context.Context, r io.Reader) ([]byte, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Contains(content, []byte(eicar)) {
+		return []byte("stream: Eicar-Test-Signature FOUND"), nil
+	}
+	return []byte("stream: OK"), nil
+}
+
+func TestPoolS3(t *testing.T) {
+	server := fakeS3(t, "quarantine", map[string][]byte{
+		"infected": []byte(eicar),
+		"clean":    []byte("clean"),
+	})
+	defer server.Close()
+
+	remotes := []Remote{{Name: "s3test", Options: map[string]string{
+		"type":              "s3",
+		"provider":          "Other",
+		"access_key_id":     "access",
+		"secret_access_key": "secret",
+		"endpoint":          server.URL,
+		"region":            "us-east-1",
+	}}}
+	if err := Register(remotes); err != nil {
+		t.Fatal(err)
+	}
+	for key := range remotes[0].Options {
+		defer os.Unsetenv(fs.ConfigToEnv("s3test", key))
+	}
+
+	pool := NewPool(quarantine.NewConfiguration("/quarantine", quarantine.Zip), []string{"s3test:"}, nil)
+	scanner := clamav.NewScanner(clamav.ScannerConfig{Engine: streamEngine{}, ScanTimeout: time.Minute, Quarantines: pool})
+
+	tests := []struct {
+		filename  string
+		positives int
+	}{
+		{"infected", 1},
+		{"clean", 0},
+	}
+
+	for _, test := range tests {
+		res, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: test.filename, Location: "s3test:quarantine"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		details := res.Details.(plugins.VirusScanResult)
+		if details.Positives != test.positives {
+			t.Fatalf("%s: Expected %d positives, Received %d", test.filename, test.positives, details.Positives)
+		}
+	}
+
+	if _, err := scanner.Scan(ipc.Scan{ID: uuid.New(), Filename: "missing", Location: "s3test:quarantine"}); err == nil {
+		t.Fatal("Expected error for missing object")
+	}
+}
@@ -0,0 +1,39 @@
+package remote
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+
+	"github.com/ncw/rclone/fs"
+
+	"github.com/worlvlhole/clamav-plugin/internal/closer"
+)
+
+//zipQuarantine reads the gzipped files written by maladapt's
+//zip quarantine. Unlike quarantine.Quarantine, closing a file
+//also closes the object it is read from, ending the download
+type zipQuarantine struct {
+	fs fs.Fs
+}
+
+//OpenFile returns a Reader of the gunzipped file
+func (q zipQuarantine) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	obj, err := q.fs.NewObject(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := obj.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return closer.NewReadCloser(gz, gz, file), nil
+}
@@ -0,0 +1,127 @@
+package sealed
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+//KeySize is the size of an AES-256 key
+const KeySize = 32
+
+//Keyring holds every key files may be sealed with and the
+//key new files are sealed with. Retired keys stay in the
+//keyring until no file sealed with them is left
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+//NewKeyring creates a keyring sealing new files with the key
+//named current. With a single key current may be empty
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("keyring has no keys")
+	}
+
+	for id, key := range keys {
+		if id == "" || len(id) > maxKeyID {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("key %q is %d bytes, expected %d", id, len(key), KeySize)
+		}
+
+		if current == "" && len(keys) == 1 {
+			current = id
+		}
+	}
+
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the keyring", current)
+	}
+
+	return &Keyring{current: current, keys: keys}, nil
+}
+
+//KeyringFromViper loads the keys in quarantine.key_file and
+//quarantine.keys, e.g. MAL_QUARANTINE_KEYS="2019-01:<base64>".
+//Both hold "id:base64 key" entries, the file one per line.
+//quarantine.key_id names the key new files are sealed with
+func KeyringFromViper(cfg *viper.Viper) (*Keyring, error) {
+	keys := map[string][]byte{}
+
+	if path := cfg.GetString("quarantine.key_file"); path != "" {
+		if err := loadKeyFile(keys, path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range strings.FieldsFunc(cfg.GetString("quarantine.keys"), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n'
+	}) {
+		if err := addKey(keys, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewKeyring(cfg.GetString("quarantine.key_id"), keys)
+}
+
+func loadKeyFile(keys map[string][]byte, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := addKey(keys, line); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, number, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func addKey(keys map[string][]byte, entry string) error {
+	i := strings.LastIndex(entry, ":")
+	if i <= 0 {
+		return fmt.Errorf("key entry is not id:base64 key")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(entry[i+1:]))
+	if err != nil {
+		return fmt.Errorf("key %q: %v", entry[:i], err)
+	}
+
+	keys[strings.TrimSpace(entry[:i])] = key
+	return nil
+}
+
+//Current returns the ID and key new files are sealed with
+func (k *Keyring) Current() (string, []byte) {
+	if k == nil {
+		return "", nil
+	}
+	return k.current, k.keys[k.current]
+}
+
+//Key returns the key named id, or nil
+func (k *Keyring) Key(id string) []byte {
+	if k == nil {
+		return nil
+	}
+	return k.keys[id]
+}
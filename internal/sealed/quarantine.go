@@ -0,0 +1,82 @@
+package sealed
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/fs/object"
+
+	"github.com/worlvlhole/clamav-plugin/internal/closer"
+)
+
+//Quarantine reads and writes sealed files on an rclone remote
+type Quarantine struct {
+	fs      fs.Fs
+	keyring *Keyring
+}
+
+//NewQuarantine creates a Quarantine of sealed files in f
+func NewQuarantine(f fs.Fs, keyring *Keyring) Quarantine {
+	return Quarantine{fs: f, keyring: keyring}
+}
+
+//OpenFile returns a Reader of the unsealed, gunzipped file
+func (q Quarantine) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	obj, err := q.fs.NewObject(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := obj.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := NewReader(file, q.keyring)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return closer.NewReadCloser(gz, gz, file), nil
+}
+
+//Write gzips and seals contents with the current key
+func (q Quarantine) Write(ctx context.Context, filename string, contents []byte) error {
+	buf := new(bytes.Buffer)
+	sealer, err := NewWriter(buf, q.keyring)
+	if err != nil {
+		return err
+	}
+
+	gz, err := gzip.NewWriterLevel(sealer, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+
+	if _, err := gz.Write(contents); err != nil {
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	if err := sealer.Close(); err != nil {
+		return err
+	}
+
+	info := object.NewStaticObjectInfo(filename, time.Now(), int64(buf.Len()), true, nil, nil)
+	_, err = q.fs.Put(buf, info)
+	return err
+}
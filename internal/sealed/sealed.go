@@ -0,0 +1,283 @@
+//Package sealed reads and writes quarantined files encrypted at
+//rest. Files are gzipped like zip quarantines, then encrypted in
+//64KiB AES-256-GCM chunks under a key named in the file header,
+//so keys can be rotated while files sealed with old keys remain
+//readable. Each file is encrypted with its own subkey, derived
+//with HKDF from the named key and a random salt in the header,
+//so the chunk nonces need only count chunks within the file
+package sealed
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+//Type is the quarantine type of sealed files
+const Type = "sealed"
+
+const (
+	magic     = "MALSEAL2"
+	chunkSize = 64 << 10
+	saltSize  = 32
+	maxKeyID  = 255
+)
+
+var (
+	//ErrFormat the file is not a sealed file
+	ErrFormat = errors.New("not a sealed file")
+	//ErrUnknownKey the file is sealed with a key missing from the keyring
+	ErrUnknownKey = errors.New("unknown key")
+	//ErrCorrupt a chunk failed authentication or data follows the last chunk
+	ErrCorrupt = errors.New("sealed file is corrupt")
+	//ErrTruncated the file ended before its last chunk
+	ErrTruncated = errors.New("sealed file is truncated")
+)
+
+//header is "MALSEAL2", the key ID length and key ID, and the
+//salt of the file's subkey. The whole header authenticates
+//every chunk
+func header(keyID string, salt []byte) []byte {
+	h := make([]byte, 0, len(magic)+1+len(keyID)+saltSize)
+	h = append(h, magic...)
+	h = append(h, byte(len(keyID)))
+	h = append(h, keyID...)
+	return append(h, salt...)
+}
+
+//nonce is the chunk counter followed by a last chunk flag
+func nonce(counter uint32, last bool) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint32(n[7:], counter)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+//newAEAD derives the subkey of the file with salt from key
+func newAEAD(key, salt []byte) (cipher.AEAD, error) {
+	subkey := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte(magic)), subkey); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(subkey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//Writer seals everything written to it. Close must
+//be called to write the last chunk
+type Writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	counter uint32
+	buf     []byte
+	err     error
+}
+
+//NewWriter writes the header of a file sealed with
+//the keyring's current key and returns its Writer
+func NewWriter(w io.Writer, keyring *Keyring) (*Writer, error) {
+	keyID, key := keyring.Current()
+	if key == nil {
+		return nil, errors.New("keyring has no current key")
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	h := header(keyID, salt)
+	if _, err := w.Write(h); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		w:      w,
+		aead:   aead,
+		header: h,
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (s *Writer) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	var written int
+	for len(p) > 0 {
+		//a full buffer is only sealed once more data arrives,
+		//so the last chunk is always sealed by Close
+		if len(s.buf) == chunkSize {
+			if s.err = s.seal(false); s.err != nil {
+				return written, s.err
+			}
+		}
+
+		n := copy(s.buf[len(s.buf):chunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+//Close seals the last chunk. It does not close the underlying writer
+func (s *Writer) Close() error {
+	if s.err != nil {
+		return s.err
+	}
+	s.err = s.seal(true)
+	if s.err == nil {
+		s.err = errors.New("sealed writer is closed")
+		return nil
+	}
+	return s.err
+}
+
+func (s *Writer) seal(last bool) error {
+	sealed := s.aead.Seal(nil, nonce(s.counter, last), s.buf, s.header)
+	s.counter++
+	s.buf = s.buf[:0]
+
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(sealed)))
+	if _, err := s.w.Write(length); err != nil {
+		return err
+	}
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+//Reader opens a sealed file
+type Reader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	counter uint32
+	buf     *bytes.Reader
+	last    bool
+}
+
+//NewReader reads the header of a sealed file and returns
+//a Reader of its content. Any key in the keyring may
+//have sealed the file
+func NewReader(r io.Reader, keyring *Keyring) (*Reader, error) {
+	fixed := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, ErrFormat
+	}
+
+	if string(fixed[:len(magic)]) != magic {
+		return nil, ErrFormat
+	}
+
+	rest := make([]byte, int(fixed[len(magic)])+saltSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, ErrFormat
+	}
+
+	keyID := string(rest[:len(rest)-saltSize])
+	key := keyring.Key(keyID)
+	if key == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+
+	aead, err := newAEAD(key, rest[len(rest)-saltSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		r:      r,
+		aead:   aead,
+		header: append(fixed, rest...),
+		buf:    bytes.NewReader(nil),
+	}, nil
+}
+
+func (s *Reader) Read(p []byte) (int, error) {
+	for s.buf.Len() == 0 {
+		if s.last {
+			return 0, io.EOF
+		}
+
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	return s.buf.Read(p)
+}
+
+//open reads and authenticates the next chunk
+func (s *Reader) open() error {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(s.r, length); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(length)
+	if size > chunkSize+uint32(s.aead.Overhead()) {
+		return ErrCorrupt
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(s.r, sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	//the last chunk is flagged in its nonce, so a file cut
+	//at a chunk boundary fails instead of reading short
+	for _, last := range []bool{false, true} {
+		plain, err := s.aead.Open(nil, nonce(s.counter, last), sealed, s.header)
+		if err != nil {
+			continue
+		}
+
+		//nothing may follow the last chunk
+		if last {
+			switch _, err := io.ReadFull(s.r, make([]byte, 1)); err {
+			case io.EOF:
+			case nil:
+				return ErrCorrupt
+			default:
+				return err
+			}
+		}
+
+		s.counter++
+		s.last = last
+		s.buf.Reset(plain)
+		return nil
+	}
+
+	return ErrCorrupt
+}
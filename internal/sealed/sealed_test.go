@@ -0,0 +1,227 @@
+package sealed
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/ncw/rclone/backend/local"
+	"github.com/ncw/rclone/fs"
+	"github.com/spf13/viper"
+)
+
+func newKey(t *testing.T) []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func seal(t *testing.T, keyring *Keyring, content []byte) []byte {
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func unseal(keyring *Keyring, sealed []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(sealed), keyring)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestSealed(t *testing.T) {
+	keyring, err := NewKeyring("", map[string][]byte{"2019-01": newKey(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17} {
+		content := make([]byte, size)
+		rand.Read(content)
+
+		sealed := seal(t, keyring, content)
+		if bytes.Contains(sealed, content) && size > 16 { //shorter content may recur in ciphertext by chance
+			t.Fatalf("%d bytes: content is not encrypted", size)
+		}
+
+		unsealed, err := unseal(keyring, sealed)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+
+		if !bytes.Equal(unsealed, content) {
+			t.Fatalf("%d bytes: unsealed content differs", size)
+		}
+	}
+}
+
+func TestSealedRotation(t *testing.T) {
+	oldKey, newKey := newKey(t), newKey(t)
+	old, err := NewKeyring("2019-01", map[string][]byte{"2019-01": oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := seal(t, old, []byte("content"))
+
+	rotated, err := NewKeyring("2019-02", map[string][]byte{"2019-01": oldKey, "2019-02": newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if unsealed, err := unseal(rotated, sealed); err != nil || string(unsealed) != "content" {
+		t.Fatalf("Expected content sealed with a retired key, Received %q %v", unsealed, err)
+	}
+
+	if id, _ := rotated.Current(); id != "2019-02" {
+		t.Fatalf("Expected new files sealed with 2019-02, Received %s", id)
+	}
+
+	retired, err := NewKeyring("", map[string][]byte{"2019-02": newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := unseal(retired, sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Expected %v, Received %v", ErrUnknownKey, err)
+	}
+}
+
+func TestSealedTampering(t *testing.T) {
+	keyring, err := NewKeyring("", map[string][]byte{"key": newKey(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := make([]byte, 2*chunkSize+10)
+	sealed := seal(t, keyring, content)
+
+	flipped := append([]byte(nil), sealed...)
+	flipped[len(flipped)-1] ^= 1
+
+	//another salt derives another subkey
+	salted := append([]byte(nil), sealed...)
+	salted[len(header("key", nil))] ^= 1
+
+	//cut after the first chunk, at a chunk boundary
+	boundary := len(header("key", make([]byte, saltSize))) + 4 + chunkSize + 16
+
+	tests := []struct {
+		name   string
+		sealed []byte
+		err    error
+	}{
+		{"flipped", flipped, ErrCorrupt},
+		{"salt", salted, ErrCorrupt},
+		{"truncated", sealed[:len(sealed)-5], ErrTruncated},
+		{"boundary", sealed[:boundary], ErrTruncated},
+		{"trailing", append(append([]byte(nil), sealed...), 0), ErrCorrupt},
+		{"gzip", []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0}, ErrFormat},
+	}
+
+	for _, test := range tests {
+		if _, err := unseal(keyring, test.sealed); !errors.Is(err, test.err) {
+			t.Fatalf("%s: Expected %v, Received %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestKeyringFromViper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sealed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldKey, newKey := newKey(t), newKey(t)
+	path := filepath.Join(dir, "keys")
+	file := "# retired\n2019-01:" + base64.StdEncoding.EncodeToString(oldKey) + "\n"
+	if err := ioutil.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := viper.New()
+	cfg.Set("quarantine.key_file", path)
+	cfg.Set("quarantine.keys", "2019-02:"+base64.StdEncoding.EncodeToString(newKey))
+	cfg.Set("quarantine.key_id", "2019-02")
+
+	keyring, err := KeyringFromViper(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(keyring.Key("2019-01"), oldKey) || !bytes.Equal(keyring.Key("2019-02"), newKey) {
+		t.Fatal("Expected keys from the key file and the environment")
+	}
+
+	cfg.Set("quarantine.key_id", "")
+	if _, err := KeyringFromViper(cfg); err == nil {
+		t.Fatal("Expected an error without a current key")
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sealed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := fs.NewFs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := NewKeyring("", map[string][]byte{"key": newKey(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewQuarantine(f, keyring)
+	if err := q.Write(context.Background(), "file", []byte("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR")); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := ioutil.ReadFile(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(stored, []byte("EICAR")) {
+		t.Fatal("Expected the quarantined file to be encrypted")
+	}
+
+	reader, err := q.OpenFile(context.Background(), "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasSuffix(content, []byte("EICAR")) {
+		t.Fatalf("Expected the quarantined content, Received %q", content)
+	}
+}
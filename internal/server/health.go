@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/go-plugin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+//ServiceName is the gRPC service of the av scanner
+const ServiceName = "proto.AVScannerPlugin"
+
+const (
+	healthCheckMethod = "/grpc.health.v1.Health/Check"
+	healthWatchMethod = "/grpc.health.v1.Health/Watch"
+)
+
+//Probe reports whether scans can be served
+type Probe func(ctx context.Context) error
+
+//Health serves grpc.health.v1 for ServiceName and the server
+//as a whole from the result of a Probe. go-plugin registers
+//its own health service on every server, so Health answers
+//through interceptors and leaves requests for go-plugin's
+//service, which only says the process is up, to go-plugin
+type Health struct {
+	probe  Probe
+	server *health.Server
+}
+
+//NewHealth creates a Health that is not serving
+//until the first successful Check
+func NewHealth(probe Probe) *Health {
+	h := &Health{probe: probe, server: health.NewServer()}
+	h.set(healthpb.HealthCheckResponse_NOT_SERVING)
+	return h
+}
+
+//set sets status for the server and ServiceName
+func (h *Health) set(status healthpb.HealthCheckResponse_ServingStatus) {
+	h.server.SetServingStatus("", status)
+	h.server.SetServingStatus(ServiceName, status)
+}
+
+//Serving reports whether the last Check passed
+func (h *Health) Serving() bool {
+	res, err := h.server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: ServiceName})
+	return err == nil && res.Status == healthpb.HealthCheckResponse_SERVING
+}
+
+//Check runs the probe and sets the serving status from its result.
+//Temporary errors, such as a busy scanner, leave the status as is
+func (h *Health) Check(ctx context.Context) error {
+	err := h.probe(ctx)
+	switch {
+	case err == nil:
+		h.set(healthpb.HealthCheckResponse_SERVING)
+	case !temporary(err):
+		h.set(healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+
+	return err
+}
+
+func temporary(err error) bool {
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
+}
+
+//Watch runs Check every interval until ctx is done,
+//logging whenever the probe starts or stops failing
+func (h *Health) Watch(ctx context.Context, interval time.Duration) {
+	logger := log.WithFields(log.Fields{"func": "Watch"})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	serving := h.Serving()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := h.Check(ctx)
+		switch {
+		case err != nil && temporary(err):
+			logger.Debug("self-test skipped: ", err)
+		case err != nil && serving:
+			logger.WithField("status", "not_serving").Error(err)
+		case err == nil && !serving:
+			logger.WithField("status", "serving").Info("self-test passed")
+		}
+		serving = h.Serving()
+	}
+}
+
+//GRPCServer creates a grpc.Server answering health checks from
+//h. It is meant for plugin.ServeConfig.GRPCServer
+func (h *Health) GRPCServer(opts []grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.UnaryInterceptor(h.unaryInterceptor),
+		grpc.StreamInterceptor(h.streamInterceptor),
+	)
+	return grpc.NewServer(opts...)
+}
+
+func (h *Health) unaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	if info.FullMethod != healthCheckMethod {
+		return handler(ctx, req)
+	}
+
+	in, ok := req.(*healthpb.HealthCheckRequest)
+	if !ok || in.Service == plugin.GRPCServiceName {
+		return handler(ctx, req)
+	}
+
+	return h.server.Check(ctx, in)
+}
+
+//streamInterceptor reads the request of a Watch to see which
+//service it is for. Watches of go-plugin's service are handed
+//a stream that returns the request again
+func (h *Health) streamInterceptor(srv interface{}, stream grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	if info.FullMethod != healthWatchMethod {
+		return handler(srv, stream)
+	}
+
+	in := new(healthpb.HealthCheckRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+
+	if in.Service == plugin.GRPCServiceName {
+		return handler(srv, &replayStream{ServerStream: stream, msg: in})
+	}
+
+	return h.server.Watch(in, &watchStream{stream})
+}
+
+//replayStream returns msg from the first RecvMsg
+type replayStream struct {
+	grpc.ServerStream
+	msg *healthpb.HealthCheckRequest
+}
+
+func (s *replayStream) RecvMsg(m interface{}) error {
+	if s.msg == nil {
+		return s.ServerStream.RecvMsg(m)
+	}
+
+	in, ok := m.(*healthpb.HealthCheckRequest)
+	if !ok {
+		return s.ServerStream.RecvMsg(m)
+	}
+
+	*in = *s.msg
+	s.msg = nil
+	return nil
+}
+
+//watchStream implements healthpb.Health_WatchServer
+type watchStream struct {
+	grpc.ServerStream
+}
+
+func (s *watchStream) Send(m *healthpb.HealthCheckResponse) error {
+	return s.ServerStream.SendMsg(m)
+}
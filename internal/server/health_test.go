@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+//toggleProbe fails while err is set
+type toggleProbe struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (p *toggleProbe) set(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+func (p *toggleProbe) probe(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+//busyError is a temporary probe failure
+type busyError struct{}
+
+func (busyError) Error() string   { return "scanner busy" }
+func (busyError) Temporary() bool { return true }
+
+//serveHealth serves h with go-plugin's own health
+//service registered, as plugin.Serve does
+func serveHealth(t *testing.T, h *Health) (healthpb.HealthClient, func()) {
+	s := h.GRPCServer(nil)
+	pluginHealth := health.NewServer()
+	pluginHealth.SetServingStatus(plugin.GRPCServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, pluginHealth)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		s.Stop()
+		t.Fatal(err)
+	}
+
+	return healthpb.NewHealthClient(conn), func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func checkStatus(t *testing.T, client healthpb.HealthClient, service string) healthpb.HealthCheckResponse_ServingStatus {
+	res, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Status
+}
+
+func TestHealth(t *testing.T) {
+	probe := &toggleProbe{}
+	h := NewHealth(probe.probe)
+	client, stop := serveHealth(t, h)
+	defer stop()
+
+	//not serving until the self-test passes, while go-plugin's service is untouched
+	for service, expected := range map[string]healthpb.HealthCheckResponse_ServingStatus{
+		"":                     healthpb.HealthCheckResponse_NOT_SERVING,
+		ServiceName:            healthpb.HealthCheckResponse_NOT_SERVING,
+		plugin.GRPCServiceName: healthpb.HealthCheckResponse_SERVING,
+	} {
+		if status := checkStatus(t, client, service); status != expected {
+			t.Fatalf("Expected %s for %q, Received %s", expected, service, status)
+		}
+	}
+
+	if err := h.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status := checkStatus(t, client, ServiceName); status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("Expected %s, Received %s", healthpb.HealthCheckResponse_SERVING, status)
+	}
+
+	//a busy scanner is still serving
+	probe.set(busyError{})
+	if err := h.Check(context.Background()); err != (busyError{}) {
+		t.Fatalf("Expected %v, Received %v", busyError{}, err)
+	}
+	if status := checkStatus(t, client, ServiceName); status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("Expected %s while busy, Received %s", healthpb.HealthCheckResponse_SERVING, status)
+	}
+
+	failure := errors.New("no signature found")
+	probe.set(failure)
+	if err := h.Check(context.Background()); err != failure {
+		t.Fatalf("Expected %v, Received %v", failure, err)
+	}
+	if status := checkStatus(t, client, ""); status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("Expected %s, Received %s", healthpb.HealthCheckResponse_NOT_SERVING, status)
+	}
+	if status := checkStatus(t, client, plugin.GRPCServiceName); status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("Expected go-plugin's service to stay %s, Received %s", healthpb.HealthCheckResponse_SERVING, status)
+	}
+}
+
+func TestHealthWatch(t *testing.T) {
+	probe := &toggleProbe{}
+	h := NewHealth(probe.probe)
+	client, stop := serveHealth(t, h)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	//go-plugin's service still answers watches
+	pluginWatch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: plugin.GRPCServiceName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := pluginWatch.Recv(); err != nil || res.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("Expected %s, Received %v, %v", healthpb.HealthCheckResponse_SERVING, res, err)
+	}
+
+	watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: ServiceName})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go h.Watch(ctx, 10*time.Millisecond)
+
+	//the periodic self-test brings the service up, then down when it fails
+	for _, expected := range []healthpb.HealthCheckResponse_ServingStatus{
+		healthpb.HealthCheckResponse_NOT_SERVING,
+		healthpb.HealthCheckResponse_SERVING,
+	} {
+		res, err := watch.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != expected {
+			t.Fatalf("Expected %s, Received %s", expected, res.Status)
+		}
+	}
+
+	probe.set(errors.New("clamscan not found"))
+	for {
+		res, err := watch.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status == healthpb.HealthCheckResponse_NOT_SERVING {
+			break
+		}
+	}
+}
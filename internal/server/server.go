@@ -0,0 +1,144 @@
+//Package server serves a clamav Scanner over the maladapt
+//av_scanner gRPC protocol. Unlike plugins.AVScannerGRPCServer
+//it hands the request context to the scanner, so the caller's
+//deadline, cancellation and trace reach the scan
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-plugin"
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/worlvlhole/maladapt/pkg/digests"
+	"github.com/worlvlhole/maladapt/pkg/ipc"
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+	"github.com/worlvlhole/maladapt/pkg/plugin/proto"
+
+	"github.com/worlvlhole/clamav-plugin/internal/tracing"
+)
+
+//ContextPlugin is a plugins.Plugin that accepts
+//a context for every scan
+type ContextPlugin interface {
+	plugins.Plugin
+	ScanContext(ctx context.Context, scan ipc.Scan) (plugins.Result, error)
+}
+
+//scanMethod names the server span of a scan
+const scanMethod = "/" + ServiceName + "/Scan"
+
+//GRPCServer implements proto.AVScannerPluginServer
+type GRPCServer struct {
+	Impl ContextPlugin
+}
+
+//Scan converts the request to an ipc.Scan and scans it under
+//the request context. Scans abandoned by the caller and
+//temporary failures are returned with a matching gRPC code
+func (g *GRPCServer) Scan(ctx context.Context,
+	req *proto.ScanRequest) (*proto.AVScanResponse, error) {
+
+	ctx, span := tracing.StartServerSpan(ctx, scanMethod)
+	defer span.End()
+
+	id, err := uuid.Parse(req.Id)
+	if err != nil {
+		return nil, spanError(span, status.Error(codes.InvalidArgument, err.Error()))
+	}
+
+	scanDigests := make([]digests.Digest, len(req.Digests))
+	for i, d := range req.Digests {
+		scanDigests[i].Algorithm = d.Algorithm
+		scanDigests[i].Hash = d.Hash
+	}
+
+	scan := ipc.Scan{
+		ID:       id,
+		Filename: req.Filename,
+		Location: req.Location,
+		Digests:  scanDigests,
+	}
+
+	result, err := g.Impl.ScanContext(ctx, scan)
+	if err != nil {
+		return nil, spanError(span, scanError(ctx, err))
+	}
+
+	if result.Type != plugins.VirusScan {
+		return nil, errors.New("invalid result type")
+	}
+
+	vsr, ok := result.Details.(plugins.VirusScanResult)
+	if !ok {
+		return nil, errors.New("invalid result type")
+	}
+
+	timestamp, err := ptypes.TimestampProto(result.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := json.Marshal(vsr.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.AVScanResponse{
+		Time: timestamp,
+		Type: result.Type,
+		Result: &proto.AVScanResponse_AVScanResult{
+			Positives:  int32(vsr.Positives),
+			TotalScans: int32(vsr.TotalScans),
+			Context:    details,
+		},
+	}, nil
+}
+
+//scanError maps err to a gRPC status. Requests whose context
+//is done get the matching code, temporary errors such as a
+//busy scanner are Unavailable so the caller retries
+func scanError(ctx context.Context, err error) error {
+	var temporary interface{ Temporary() bool }
+	switch {
+	case ctx.Err() == context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	case ctx.Err() == context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.As(err, &temporary) && temporary.Temporary():
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return err
+	}
+}
+
+//spanError sets the status of span from err and returns err
+func spanError(span *trace.Span, err error) error {
+	span.SetStatus(trace.Status{Code: int32(status.Code(err)), Message: err.Error()})
+	return err
+}
+
+//GRPCPlugin is the plugin.GRPCPlugin serving a ContextPlugin.
+//Clients are the stock plugins.AVScannerGRPCClient
+type GRPCPlugin struct {
+	plugin.Plugin
+	Impl ContextPlugin
+}
+
+//GRPCServer implements plugin.GRPCPlugin
+func (p *GRPCPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
+	proto.RegisterAVScannerPluginServer(s, &GRPCServer{Impl: p.Impl})
+	return nil
+}
+
+//GRPCClient implements plugin.GRPCPlugin
+func (p *GRPCPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return (&plugins.AVScannerGRPCPlugin{}).GRPCClient(ctx, broker, c)
+}
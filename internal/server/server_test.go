@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/worlvlhole/maladapt/pkg/ipc"
+	"github.com/worlvlhole/maladapt/pkg/plugin"
+	"github.com/worlvlhole/maladapt/pkg/plugin/proto"
+
+	"github.com/worlvlhole/clamav-plugin/internal/tracing"
+)
+
+//fakePlugin returns a clean result unless wait is set,
+//in which case it blocks until the scan is cancelled
+type fakePlugin struct {
+	wait bool
+	err  error
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "busy" }
+func (temporaryError) Temporary() bool { return true }
+
+func (f fakePlugin) Scan(scan ipc.Scan) (plugins.Result, error) {
+	return f.ScanContext(context.Background(), scan)
+}
+
+func (f fakePlugin) ScanContext(ctx context.Context, scan ipc.Scan) (plugins.Result, error) {
+	if f.wait {
+		<-ctx.Done()
+		return plugins.Result{}, ctx.Err()
+	}
+
+	if f.err != nil {
+		return plugins.Result{}, f.err
+	}
+
+	return plugins.Result{
+		Time: time.Now(),
+		Type: plugins.VirusScan,
+		Details: plugins.VirusScanResult{
+			Positives:  1,
+			TotalScans: 1,
+			Context:    map[string]string{"status": "infected"},
+		},
+	}, nil
+}
+
+func TestGRPCServerScan(t *testing.T) {
+	server := &GRPCServer{Impl: fakePlugin{}}
+	resp, err := server.Scan(context.Background(), &proto.ScanRequest{Id: uuid.New().String(), Filename: "file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Type != plugins.VirusScan || resp.Result.Positives != 1 || resp.Result.TotalScans != 1 {
+		t.Fatalf("Unexpected response %+v", resp)
+	}
+
+	var details map[string]string
+	if err := json.Unmarshal(resp.Result.Context, &details); err != nil {
+		t.Fatal(err)
+	}
+
+	if details["status"] != "infected" {
+		t.Fatalf("Expected infected context, Received %v", details)
+	}
+
+	if _, err := server.Scan(context.Background(), &proto.ScanRequest{Id: "not a uuid"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected %s, Received %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestGRPCServerCancelled(t *testing.T) {
+	server := &GRPCServer{Impl: fakePlugin{wait: true}}
+	req := &proto.ScanRequest{Id: uuid.New().String(), Filename: "file"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := server.Scan(ctx, req); status.Code(err) != codes.Canceled {
+		t.Fatalf("Expected %s, Received %v", codes.Canceled, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := server.Scan(ctx, req); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("Expected %s, Received %v", codes.DeadlineExceeded, err)
+	}
+}
+
+func TestGRPCServerBusy(t *testing.T) {
+	server := &GRPCServer{Impl: fakePlugin{err: temporaryError{}}}
+	req := &proto.ScanRequest{Id: uuid.New().String(), Filename: "file"}
+	if _, err := server.Scan(context.Background(), req); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Expected %s, Received %v", codes.Unavailable, err)
+	}
+}
+
+//spanPlugin keeps the span context each scan ran under
+type spanPlugin struct {
+	fakePlugin
+	spanContext trace.SpanContext
+}
+
+func (s *spanPlugin) ScanContext(ctx context.Context, scan ipc.Scan) (plugins.Result, error) {
+	s.spanContext = trace.FromContext(ctx).SpanContext()
+	return s.fakePlugin.ScanContext(ctx, scan)
+}
+
+func TestGRPCServerTrace(t *testing.T) {
+	parent := trace.SpanContext{
+		TraceID:      trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		SpanID:       trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		TraceOptions: 1,
+	}
+
+	plugin := &spanPlugin{}
+	server := &GRPCServer{Impl: plugin}
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(tracing.BinaryHeader, string(tracing.ToBinary(parent))))
+	if _, err := server.Scan(ctx, &proto.ScanRequest{Id: uuid.New().String(), Filename: "file"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if plugin.spanContext.TraceID != parent.TraceID || plugin.spanContext.SpanID == parent.SpanID ||
+		!plugin.spanContext.IsSampled() {
+		t.Fatalf("Expected a sampled span in trace %s, Received %+v", parent.TraceID, plugin.spanContext)
+	}
+}
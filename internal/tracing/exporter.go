@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opencensus.io/trace"
+)
+
+//Span is the JSON form of an exported span
+type Span struct {
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	RemoteParent bool                   `json:"remote_parent,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind,omitempty"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Duration     time.Duration          `json:"duration"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	StatusCode   int32                  `json:"status_code,omitempty"`
+	Status       string                 `json:"status,omitempty"`
+}
+
+//Exporter implements trace.Exporter by writing
+//every span to w as a line of JSON
+type Exporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+//NewExporter creates an Exporter writing to w
+func NewExporter(w io.Writer) *Exporter {
+	return &Exporter{enc: json.NewEncoder(w)}
+}
+
+//ExportSpan implements trace.Exporter
+func (e *Exporter) ExportSpan(data *trace.SpanData) {
+	span := Span{
+		TraceID:      data.TraceID.String(),
+		SpanID:       data.SpanID.String(),
+		RemoteParent: data.HasRemoteParent,
+		Name:         data.Name,
+		Kind:         spanKind(data.SpanKind),
+		Start:        data.StartTime,
+		End:          data.EndTime,
+		Duration:     data.EndTime.Sub(data.StartTime),
+		Attributes:   data.Attributes,
+		StatusCode:   data.Code,
+		Status:       data.Message,
+	}
+	if data.ParentSpanID != (trace.SpanID{}) {
+		span.ParentSpanID = data.ParentSpanID.String()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(span); err != nil {
+		log.WithFields(log.Fields{"func": "ExportSpan"}).Error(err)
+	}
+}
+
+func spanKind(kind int) string {
+	switch kind {
+	case trace.SpanKindServer:
+		return "server"
+	case trace.SpanKindClient:
+		return "client"
+	default:
+		return ""
+	}
+}
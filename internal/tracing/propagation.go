@@ -0,0 +1,130 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+//metadata keys carrying the caller's span
+const (
+	//BinaryHeader is the OpenCensus gRPC binary format
+	BinaryHeader = "grpc-trace-bin"
+	//TraceparentHeader is the W3C trace context format
+	TraceparentHeader = "traceparent"
+)
+
+//StartServerSpan starts a server span for a gRPC method. It is
+//a child of the caller's span when the incoming metadata has one
+func StartServerSpan(ctx context.Context, method string) (context.Context, *trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if parent, ok := FromMetadata(md); ok {
+		return trace.StartSpanWithRemoteParent(ctx, method, parent, trace.WithSpanKind(trace.SpanKindServer))
+	}
+	return trace.StartSpan(ctx, method, trace.WithSpanKind(trace.SpanKindServer))
+}
+
+//FromMetadata returns the span context sent by the caller,
+//preferring the binary format over traceparent
+func FromMetadata(md metadata.MD) (trace.SpanContext, bool) {
+	for _, value := range md.Get(BinaryHeader) {
+		if sc, ok := FromBinary([]byte(value)); ok {
+			return sc, true
+		}
+	}
+
+	for _, value := range md.Get(TraceparentHeader) {
+		if sc, ok := FromTraceparent(value); ok {
+			return sc, true
+		}
+	}
+
+	return trace.SpanContext{}, false
+}
+
+//FromBinary decodes the OpenCensus binary format: a version
+//byte followed by the trace id, span id and options fields,
+//each prefixed by its field id
+func FromBinary(b []byte) (trace.SpanContext, bool) {
+	var sc trace.SpanContext
+	if len(b) == 0 || b[0] != 0 {
+		return sc, false
+	}
+	b = b[1:]
+
+	//fields are in order, unknown fields end the parse
+	if len(b) >= 1+len(sc.TraceID) && b[0] == 0 {
+		copy(sc.TraceID[:], b[1:])
+		b = b[1+len(sc.TraceID):]
+	} else {
+		return sc, false
+	}
+
+	if len(b) >= 1+len(sc.SpanID) && b[0] == 1 {
+		copy(sc.SpanID[:], b[1:])
+		b = b[1+len(sc.SpanID):]
+	} else {
+		return sc, false
+	}
+
+	if len(b) >= 2 && b[0] == 2 {
+		sc.TraceOptions = trace.TraceOptions(b[1])
+	}
+
+	return sc, valid(sc)
+}
+
+//FromTraceparent decodes a W3C traceparent such as
+//00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func FromTraceparent(value string) (trace.SpanContext, bool) {
+	var sc trace.SpanContext
+
+	fields := strings.Split(strings.TrimSpace(value), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" {
+		return sc, false
+	}
+
+	//version 00 has exactly four fields, later ones may add more
+	if fields[0] == "00" && len(fields) != 4 {
+		return sc, false
+	}
+
+	if !decodeHex(sc.TraceID[:], fields[1]) || !decodeHex(sc.SpanID[:], fields[2]) {
+		return sc, false
+	}
+
+	var options [1]byte
+	if !decodeHex(options[:], fields[3]) {
+		return sc, false
+	}
+	sc.TraceOptions = trace.TraceOptions(options[0] & 1)
+
+	return sc, valid(sc)
+}
+
+//ToBinary encodes sc in the OpenCensus binary format
+func ToBinary(sc trace.SpanContext) []byte {
+	b := make([]byte, 0, 29)
+	b = append(b, 0, 0)
+	b = append(b, sc.TraceID[:]...)
+	b = append(b, 1)
+	b = append(b, sc.SpanID[:]...)
+	return append(b, 2, byte(sc.TraceOptions))
+}
+
+//decodeHex decodes exactly len(dst) bytes of lowercase hex
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+//valid rejects the all zero ids both formats reserve as invalid
+func valid(sc trace.SpanContext) bool {
+	return sc.TraceID != trace.TraceID{} && sc.SpanID != trace.SpanID{}
+}
@@ -0,0 +1,113 @@
+//Package tracing exports OpenCensus spans of scans and
+//continues the traces of callers from gRPC metadata
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.opencensus.io/trace"
+)
+
+const (
+	//ExporterNone does not export spans
+	ExporterNone string = "none"
+	//ExporterStderr writes spans to stderr, which go-plugin
+	//forwards to the host's log. Stdout is not an option, it
+	//carries the handshake and is not read after it
+	ExporterStderr string = "stderr"
+	//ExporterFile appends spans to a file
+	ExporterFile string = "file"
+
+	defaultSampleRate = 1.0
+)
+
+//Configuration defines how spans are sampled and exported
+type Configuration struct {
+	Exporter   string
+	File       string
+	SampleRate float64
+}
+
+//NewConfigurationFromViper creates a Configuration from the values
+//provided by the viper instance
+func NewConfigurationFromViper(cfg *viper.Viper) Configuration {
+	return NewConfiguration(
+		cfg.GetString("tracing.exporter"),
+		cfg.GetString("tracing.file"),
+		cfg.GetFloat64("tracing.sample_rate"),
+	)
+}
+
+//NewConfiguration creates a new Configuration from the provided values
+func NewConfiguration(exporter, file string, sampleRate float64) Configuration {
+	if exporter == "" {
+		exporter = ExporterNone
+	}
+
+	if sampleRate == 0 {
+		sampleRate = defaultSampleRate
+	}
+
+	return Configuration{
+		Exporter:   strings.ToLower(exporter),
+		File:       file,
+		SampleRate: sampleRate,
+	}
+}
+
+//Validate implements the Validate interface.
+func (c *Configuration) Validate() error {
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("tracing sample rate %v is not between 0 and 1", c.SampleRate)
+	}
+
+	switch c.Exporter {
+	case ExporterNone, ExporterStderr:
+		return nil
+	case ExporterFile:
+	default:
+		return fmt.Errorf("invalid tracing exporter %q", c.Exporter)
+	}
+
+	if c.File == "" {
+		return errors.New("tracing file is empty")
+	}
+
+	return nil
+}
+
+//Start registers the configured exporter and sampler. The
+//returned func unregisters the exporter and closes its file
+func Start(c Configuration) (func() error, error) {
+	var w io.Writer
+	closer := func() error { return nil }
+
+	switch c.Exporter {
+	case ExporterNone:
+		return closer, nil
+	case ExporterStderr:
+		w = os.Stderr
+	case ExporterFile:
+		file, err := os.OpenFile(c.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, err
+		}
+		w, closer = file, file.Close
+	default:
+		return nil, fmt.Errorf("invalid tracing exporter %q", c.Exporter)
+	}
+
+	exporter := NewExporter(w)
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(c.SampleRate)})
+	trace.RegisterExporter(exporter)
+
+	return func() error {
+		trace.UnregisterExporter(exporter)
+		return closer()
+	}, nil
+}
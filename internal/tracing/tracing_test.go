@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+var parent = trace.SpanContext{
+	TraceID:      trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+	SpanID:       trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	TraceOptions: 1,
+}
+
+func TestFromMetadata(t *testing.T) {
+	tests := []struct {
+		md metadata.MD
+		ok bool
+	}{
+		{metadata.Pairs(BinaryHeader, string(ToBinary(parent))), true},
+		{metadata.Pairs(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"), true},
+		{metadata.Pairs(BinaryHeader, "garbage", TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"), true},
+		{metadata.Pairs(TraceparentHeader, "00-00000000000000000000000000000000-00f067aa0ba902b7-01"), false},
+		{metadata.Pairs(TraceparentHeader, "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"), false},
+		{metadata.Pairs(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"), false},
+		{metadata.Pairs(TraceparentHeader, "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"), false},
+		{metadata.Pairs(BinaryHeader, string([]byte{0, 0, 1, 2})), false},
+		{metadata.MD{}, false},
+	}
+
+	for _, test := range tests {
+		sc, ok := FromMetadata(test.md)
+		if ok != test.ok {
+			t.Fatalf("Expected %v, Received %v for %v", test.ok, ok, test.md)
+		}
+		if ok && sc != parent {
+			t.Fatalf("Expected %+v, Received %+v for %v", parent, sc, test.md)
+		}
+	}
+}
+
+func TestStartServerSpan(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := NewExporter(&buf)
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	ctx, span := StartServerSpan(ctx, "/proto.AVScannerPlugin/Scan")
+	_, child := trace.StartSpan(ctx, "clamav.Scan")
+	child.AddAttributes(trace.StringAttribute("verdict", "clean"))
+	child.End()
+	span.End()
+
+	var spans []Span
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var span Span
+		if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+			t.Fatal(err)
+		}
+		spans = append(spans, span)
+	}
+
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, Received %d", len(spans))
+	}
+
+	scanSpan, server := spans[0], spans[1]
+	if server.TraceID != parent.TraceID.String() || server.ParentSpanID != parent.SpanID.String() ||
+		!server.RemoteParent || server.Kind != "server" {
+		t.Fatalf("Expected a server span continuing %+v, Received %+v", parent, server)
+	}
+
+	if scanSpan.TraceID != server.TraceID || scanSpan.ParentSpanID != server.SpanID ||
+		scanSpan.Attributes["verdict"] != "clean" {
+		t.Fatalf("Expected a child of %+v, Received %+v", server, scanSpan)
+	}
+}
+
+func TestConfiguration(t *testing.T) {
+	tests := []struct {
+		cfg   Configuration
+		valid bool
+	}{
+		{NewConfiguration("", "", 0), true},
+		{NewConfiguration("STDERR", "", 0.5), true},
+		{NewConfiguration("file", "/tmp/spans.jsonl", 0), true},
+		{NewConfiguration("file", "", 0), false},
+		{NewConfiguration("jaeger", "", 0), false},
+		{NewConfiguration("stdout", "", 0), false},
+		{NewConfiguration("stderr", "", 2), false},
+	}
+
+	for _, test := range tests {
+		if err := test.cfg.Validate(); (err == nil) != test.valid {
+			t.Fatalf("Expected valid %v, Received %v for %+v", test.valid, err, test.cfg)
+		}
+	}
+}
+
+func TestStartFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "spans.jsonl")
+	stop, err := Start(NewConfiguration(ExporterFile, file, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, span := trace.StartSpan(context.Background(), "clamav.Scan")
+	span.End()
+
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var exported Span
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatal(err)
+	}
+
+	if exported.Name != "clamav.Scan" || exported.TraceID == "" {
+		t.Fatalf("Unexpected span %+v", exported)
+	}
+}